@@ -0,0 +1,302 @@
+package forum
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// checkPassword returns errInvalidPassword unless password is the user's
+func checkPassword(db *sql.DB, userID int, password string) error {
+	var hash string
+	if err := db.QueryRow("SELECT password FROM users WHERE user_ID = ?", userID).Scan(&hash); err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return errInvalidPassword
+	}
+	return nil
+}
+
+// revokeOtherSessions ends the user's sessions except the current one and
+// returns how many were ended
+func revokeOtherSessions(db *sql.DB, session *Session) (int, error) {
+	revoked, err := getOtherSessionIDs(db, session.UserID, session.ID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec("DELETE FROM sessions WHERE user_ID = ? AND session_ID != ?", session.UserID, session.ID); err != nil {
+		return 0, err
+	}
+	for _, id := range revoked {
+		hub.revokeSession(id)
+	}
+	return len(revoked), nil
+}
+
+// UpdateProfileHandler lets a user change their names, email and gender.
+// The username and age given at registration stay as they are. Password
+// reset links go to the email, so changing it needs the current password
+// and ends the user's other sessions, like changing the password does.
+func UpdateProfileHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req UpdateProfileRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permProfile)
+	if !ok {
+		return
+	}
+	if !requireFields(client, env, "email", req.Email, "first-name", req.FirstName, "last-name", req.LastName, "gender", req.Gender) {
+		return
+	}
+
+	// Emails are unique and stored in lowercase, like at registration
+	lowercaseEmail := strings.ToLower(strings.TrimSpace(req.Email))
+	var currentEmail string
+	if err := db.QueryRow("SELECT email FROM users WHERE user_ID = ?", session.UserID).Scan(&currentEmail); err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	emailChanged := lowercaseEmail != strings.ToLower(currentEmail)
+	if emailChanged {
+		if !requireFields(client, env, "currentPassword", req.CurrentPassword) {
+			return
+		}
+		err := checkPassword(db, session.UserID, req.CurrentPassword)
+		if err == errInvalidPassword {
+			sendError(client, env, err.Error())
+			return
+		} else if err != nil {
+			sendError(client, env, "Database error")
+			log.Println("Database error:", err)
+			return
+		}
+	}
+
+	var taken int
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE LOWER(email) = ? AND user_ID != ?", lowercaseEmail, session.UserID).Scan(&taken)
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	if taken > 0 {
+		sendError(client, env, "Email is already in use")
+		return
+	}
+
+	_, err = db.Exec("UPDATE users SET email = ?, first_name = ?, last_name = ?, gender = ? WHERE user_ID = ?",
+		lowercaseEmail, strings.TrimSpace(req.FirstName), strings.TrimSpace(req.LastName), req.Gender, session.UserID)
+	if err != nil {
+		sendError(client, env, "Failed to update profile")
+		log.Println("Database error:", err)
+		return
+	}
+	revoked := 0
+	if emailChanged {
+		if revoked, err = revokeOtherSessions(db, session); err != nil {
+			sendError(client, env, "Failed to end other sessions")
+			log.Println("Database error:", err)
+			return
+		}
+	}
+	profile, err := GetProfile(db, session.UserID, session.UserID)
+	if err != nil {
+		sendError(client, env, "Failed to get profile")
+		log.Println("Failed to get profile:", err)
+		return
+	}
+
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "profileUpdated", ID: env.ID, Success: true, Message: "Profile updated", Data: map[string]interface{}{
+		"profile":         profile,
+		"revokedSessions": revoked,
+	}})
+}
+
+// ChangePasswordHandler replaces the user's password once the old one is
+// confirmed. The user's other sessions are ended, the current one stays.
+func ChangePasswordHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req ChangePasswordRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permProfile)
+	if !ok {
+		return
+	}
+	if !requireFields(client, env, "oldPassword", req.OldPassword, "newPassword", req.NewPassword) {
+		return
+	}
+
+	err := checkPassword(db, session.UserID, req.OldPassword)
+	if err == errInvalidPassword {
+		sendError(client, env, err.Error())
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		sendError(client, env, "Password hashing error")
+		log.Println("Password hashing error:", err)
+		return
+	}
+	if _, err := db.Exec("UPDATE users SET password = ? WHERE user_ID = ?", hashedPassword, session.UserID); err != nil {
+		sendError(client, env, "Failed to change password")
+		log.Println("Database error:", err)
+		return
+	}
+
+	revoked, err := revokeOtherSessions(db, session)
+	if err != nil {
+		sendError(client, env, "Failed to end other sessions")
+		log.Println("Database error:", err)
+		return
+	}
+
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "passwordChanged", ID: env.ID, Success: true, Message: "Password changed", Data: map[string]interface{}{
+		"revokedSessions": revoked,
+	}})
+}
+
+// deleteAccount removes what belongs to a user alone and keeps what others
+// rely on:
+//   - the user's posts and comments are soft-deleted and leave tombstones,
+//     like deleting them one by one, so replies by others stay in place
+//   - the user's reactions, category subscriptions and sessions are removed
+//   - private messages are kept, so the other side keeps the conversation and
+//     reported messages keep their content; only the user's own message
+//     reactions are removed
+//   - reports, bans and the moderation log are kept for the audit trail
+//   - the users row is kept with the personal fields cleared and no password,
+//     so the username cannot be registered again by someone else
+//
+// The IDs of the ended sessions are returned.
+func deleteAccount(db *sql.DB, userID int) ([]int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT session_ID FROM sessions WHERE user_ID = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	var sessionIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	deletedAt := time.Now().UTC().Format(timestampFormat)
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		// Comments on the user's posts go with them, as when a post is deleted
+		{"UPDATE comments SET deleted_at = ? WHERE deleted_at IS NULL AND (user_ID = ? OR post_ID IN (SELECT post_ID FROM posts WHERE user_ID = ?))", []interface{}{deletedAt, userID, userID}},
+		{"UPDATE posts SET deleted_at = ? WHERE user_ID = ? AND deleted_at IS NULL", []interface{}{deletedAt, userID}},
+		{"DELETE FROM reactions WHERE user_ID = ?", []interface{}{userID}},
+		{"DELETE FROM message_reactions WHERE user_ID = ?", []interface{}{userID}},
+		{"DELETE FROM category_subscriptions WHERE user_ID = ?", []interface{}{userID}},
+		{"DELETE FROM sessions WHERE user_ID = ?", []interface{}{userID}},
+		{`UPDATE users SET email = '', first_name = '', last_name = '', password = '', age = 0, gender = '',
+		         show_name = 0, show_email = 0, show_age = 0, show_gender = 0, deleted_at = ?
+		  WHERE user_ID = ?`, []interface{}{deletedAt, userID}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	return sessionIDs, tx.Commit()
+}
+
+// DeleteAccountHandler deletes the user's account once the password is
+// confirmed and logs the user out everywhere
+func DeleteAccountHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req DeleteAccountRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permProfile)
+	if !ok {
+		return
+	}
+	if !requireFields(client, env, "password", req.Password) {
+		return
+	}
+
+	err := checkPassword(db, session.UserID, req.Password)
+	if err == errInvalidPassword {
+		sendError(client, env, err.Error())
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	// The forum is never left without an admin
+	if session.Role == RoleAdmin {
+		var admins int
+		if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ? AND deleted_at IS NULL", RoleAdmin).Scan(&admins); err != nil {
+			sendError(client, env, "Database error")
+			log.Println("Database error:", err)
+			return
+		}
+		if admins <= 1 {
+			sendError(client, env, "The last admin cannot delete their account")
+			return
+		}
+	}
+
+	sessionIDs, err := deleteAccount(db, session.UserID)
+	if err != nil {
+		sendError(client, env, "Failed to delete account")
+		log.Println("Database error:", err)
+		return
+	}
+
+	// Answer before the connection is logged out with the rest of the sessions
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "accountDeleted", ID: env.ID, Success: true, Message: "Account deleted", Data: map[string]interface{}{
+		"username": session.Username,
+	}})
+	for _, id := range sessionIDs {
+		hub.revokeSession(id)
+	}
+
+	// Others drop the user's posts and comments from what they show
+	hub.BroadcastExcept(client, "userDeleted", map[string]interface{}{
+		"username": session.Username,
+	})
+	usersOnline, err := GetAllOnlineUsers(db)
+	if err != nil {
+		log.Println("Failed to get all online users:", err)
+		return
+	}
+	hub.Broadcast("updatAllUsersOnline", struct {
+		AllUsersOnline []OnlineUser `json:"usersOnline"`
+	}{
+		AllUsersOnline: usersOnline,
+	})
+}
@@ -0,0 +1,222 @@
+package forum
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Ban keeps a user from logging in or acting on the forum. A ban without
+// Until is permanent, one with Until is a suspension ending at that time.
+// Bans are errors so login can report them as they are.
+type Ban struct {
+	Reason string `json:"reason"`
+	Until  string `json:"until,omitempty"`
+}
+
+func (b *Ban) Error() string {
+	if b.Until == "" {
+		return "This account is banned: " + b.Reason
+	}
+	return "This account is suspended until " + b.Until + ": " + b.Reason
+}
+
+// getActiveBan returns the ban keeping a user out right now, or nil
+func getActiveBan(db *sql.DB, userID int) (*Ban, error) {
+	var ban Ban
+	err := db.QueryRow(`
+		SELECT reason, COALESCE(until, '')
+		FROM bans
+		WHERE user_ID = ? AND lifted_at IS NULL AND (until IS NULL OR until > ?)
+		ORDER BY ban_ID DESC
+		LIMIT 1
+	`, userID, time.Now().UTC().Format(timestampFormat)).Scan(&ban.Reason, &ban.Until)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &ban, nil
+}
+
+// banUser replaces any ban the user has with a new one and ends all of the
+// user's sessions. The IDs of the ended sessions are returned.
+func banUser(db *sql.DB, userID, bannedBy int, ban Ban) ([]int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(timestampFormat)
+	if _, err := tx.Exec("UPDATE bans SET lifted_at = ? WHERE user_ID = ? AND lifted_at IS NULL", now, userID); err != nil {
+		return nil, err
+	}
+	var until interface{}
+	if ban.Until != "" {
+		until = ban.Until
+	}
+	_, err = tx.Exec("INSERT INTO bans (user_ID, banned_by, reason, created_at, until) VALUES (?, ?, ?, ?, ?)",
+		userID, bannedBy, ban.Reason, now, until)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT session_ID FROM sessions WHERE user_ID = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	var sessionIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_ID = ?", userID); err != nil {
+		return nil, err
+	}
+
+	return sessionIDs, tx.Commit()
+}
+
+// BanUserHandler lets an admin ban a user, or suspend them when the request
+// says until when. The user is logged out everywhere.
+func BanUserHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req BanRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permBanUsers)
+	if !ok {
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if !requireFields(client, env, "username", req.Username, "reason", req.Reason) {
+		return
+	}
+
+	ban := Ban{Reason: req.Reason}
+	if req.Until != "" {
+		until, err := time.Parse(time.RFC3339, req.Until)
+		if err != nil {
+			sendError(client, env, "Invalid ban payload: until must be an RFC 3339 time")
+			return
+		}
+		if !until.After(time.Now()) {
+			sendError(client, env, "A suspension must end in the future")
+			return
+		}
+		ban.Until = until.UTC().Format(timestampFormat)
+	}
+
+	userID, username, err := getUser(req.Username, db)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "User not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	var role string
+	if err := db.QueryRow("SELECT role FROM users WHERE user_ID = ?", userID).Scan(&role); err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	// Admins cannot ban themselves or each other
+	if hasPermission(role, permBanUsers) {
+		sendError(client, env, "Admins cannot be banned")
+		return
+	}
+
+	sessionIDs, err := banUser(db, userID, session.UserID, ban)
+	if err != nil {
+		sendError(client, env, "Failed to ban user")
+		log.Println("Database error:", err)
+		return
+	}
+	action := "banUser"
+	if ban.Until != "" {
+		action = "suspendUser"
+	}
+	if err := logModeration(db, session.UserID, action, "user", userID, ban.Error()); err != nil {
+		log.Println("Failed to write moderation log:", err)
+	}
+
+	// Tell the user why before their connections are logged out
+	hub.SendToUser(userID, "accountBanned", ban)
+	for _, id := range sessionIDs {
+		hub.revokeSession(id)
+	}
+
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "userBanned", ID: env.ID, Success: true, Message: "User banned", Data: map[string]interface{}{
+		"username": username,
+		"reason":   ban.Reason,
+		"until":    ban.Until,
+	}})
+
+	usersOnline, err := GetAllOnlineUsers(db)
+	if err != nil {
+		log.Println("Failed to get all online users:", err)
+		return
+	}
+	hub.Broadcast("updatAllUsersOnline", struct {
+		AllUsersOnline []OnlineUser `json:"usersOnline"`
+	}{
+		AllUsersOnline: usersOnline,
+	})
+}
+
+// UnbanUserHandler lets an admin lift a user's ban or suspension early
+func UnbanUserHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req UserRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permBanUsers)
+	if !ok {
+		return
+	}
+	if !requireFields(client, env, "username", req.Username) {
+		return
+	}
+
+	userID, username, err := getUser(req.Username, db)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "User not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	now := time.Now().UTC().Format(timestampFormat)
+	result, err := db.Exec("UPDATE bans SET lifted_at = ? WHERE user_ID = ? AND lifted_at IS NULL AND (until IS NULL OR until > ?)", now, userID, now)
+	if err != nil {
+		sendError(client, env, "Failed to lift ban")
+		log.Println("Database error:", err)
+		return
+	}
+	if lifted, err := result.RowsAffected(); err != nil || lifted == 0 {
+		sendError(client, env, "User is not banned")
+		return
+	}
+	if err := logModeration(db, session.UserID, "unbanUser", "user", userID, ""); err != nil {
+		log.Println("Failed to write moderation log:", err)
+	}
+
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "userUnbanned", ID: env.ID, Success: true, Message: "Ban lifted", Data: map[string]interface{}{
+		"username": username,
+	}})
+}
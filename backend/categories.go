@@ -0,0 +1,280 @@
+package forum
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxCategoryName bounds the length of a category name
+const maxCategoryName = 30
+
+// checkCategoryName returns the error to show for a category name, or "".
+// Posts list their categories separated by spaces, so names cannot have any.
+// Names are unique regardless of case; excludeID is the category being
+// renamed, 0 for a new one.
+func checkCategoryName(db *sql.DB, name string, excludeID int) (string, error) {
+	if utf8.RuneCountInString(name) > maxCategoryName {
+		return "Category names can be at most 30 characters", nil
+	}
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return "Category names cannot contain spaces", nil
+	}
+	var exists int
+	err := db.QueryRow("SELECT 1 FROM categories WHERE category = ? COLLATE NOCASE AND category_ID != ?", name, excludeID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return "Category " + name + " already exists", nil
+}
+
+// getCategory returns one category, or sql.ErrNoRows
+func getCategory(db *sql.DB, categoryID int) (Category, error) {
+	var category Category
+	err := db.QueryRow(`
+		SELECT category_ID, category, description, position, archived_at IS NOT NULL
+		FROM categories
+		WHERE category_ID = ?
+	`, categoryID).Scan(&category.CategoryID, &category.Category, &category.Description, &category.Position, &category.Archived)
+	return category, err
+}
+
+// CreateCategoryHandler lets an admin add a category after the existing ones
+func CreateCategoryHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req CategoryRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permManageCategories)
+	if !ok {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if !requireFields(client, env, "name", req.Name) {
+		return
+	}
+	problem, err := checkCategoryName(db, req.Name, 0)
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	} else if problem != "" {
+		sendError(client, env, problem)
+		return
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO categories (category, description, position)
+		VALUES (?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM categories))
+	`, req.Name, strings.TrimSpace(req.Description))
+	if err != nil {
+		sendError(client, env, "Failed to create category")
+		log.Println("Database error:", err)
+		return
+	}
+	categoryID, err := result.LastInsertId()
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	category, err := getCategory(db, int(categoryID))
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	if err := logModeration(db, session.UserID, "createCategory", "category", category.CategoryID, category.Category); err != nil {
+		log.Println("Failed to write moderation log:", err)
+	}
+
+	// Everyone lists the categories, so everyone gets the new one
+	responseData := map[string]interface{}{
+		"category": category,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "categoryCreated", ID: env.ID, Success: true, Message: "Category created", Data: responseData})
+	hub.BroadcastExcept(client, "categoryCreated", responseData)
+}
+
+// UpdateCategoryHandler lets an admin rename a category and change its
+// description. Posts stay in the category, they are linked by its ID.
+func UpdateCategoryHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req CategoryRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permManageCategories)
+	if !ok {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if !requireFields(client, env, "name", req.Name) {
+		return
+	}
+
+	old, err := getCategory(db, req.CategoryID)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "Category not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	problem, err := checkCategoryName(db, req.Name, req.CategoryID)
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	} else if problem != "" {
+		sendError(client, env, problem)
+		return
+	}
+
+	_, err = db.Exec("UPDATE categories SET category = ?, description = ? WHERE category_ID = ?", req.Name, strings.TrimSpace(req.Description), req.CategoryID)
+	if err != nil {
+		sendError(client, env, "Failed to update category")
+		log.Println("Database error:", err)
+		return
+	}
+	category, err := getCategory(db, req.CategoryID)
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	note := category.Category
+	if old.Category != category.Category {
+		note = old.Category + " → " + category.Category
+	}
+	if err := logModeration(db, session.UserID, "updateCategory", "category", category.CategoryID, note); err != nil {
+		log.Println("Failed to write moderation log:", err)
+	}
+
+	// Posts show their category names, previous lets clients rename it in them
+	responseData := map[string]interface{}{
+		"category": category,
+		"previous": old.Category,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "categoryUpdated", ID: env.ID, Success: true, Message: "Category updated", Data: responseData})
+	hub.BroadcastExcept(client, "categoryUpdated", responseData)
+}
+
+var errCategoryOrder = errors.New("The new order must list every category once")
+
+// reorderCategories numbers the categories in the order of ids, which must
+// hold every category ID once
+func reorderCategories(db *sql.DB, ids []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM categories").Scan(&count); err != nil {
+		return err
+	}
+	if len(ids) != count {
+		return errCategoryOrder
+	}
+	seen := make(map[int]bool)
+	for i, id := range ids {
+		if seen[id] {
+			return errCategoryOrder
+		}
+		seen[id] = true
+		result, err := tx.Exec("UPDATE categories SET position = ? WHERE category_ID = ?", i+1, id)
+		if err != nil {
+			return err
+		}
+		if updated, err := result.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			return errCategoryOrder
+		}
+	}
+	return tx.Commit()
+}
+
+// ReorderCategoriesHandler lets an admin choose the order categories are listed in
+func ReorderCategoriesHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req ReorderCategoriesRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	if _, ok := requirePermission(client, db, env, permManageCategories); !ok {
+		return
+	}
+
+	err := reorderCategories(db, req.CategoryIDs)
+	if err == errCategoryOrder {
+		sendError(client, env, err.Error())
+		return
+	} else if err != nil {
+		sendError(client, env, "Failed to reorder categories")
+		log.Println("Database error:", err)
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"categoryIDs": req.CategoryIDs,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "categoriesReordered", ID: env.ID, Success: true, Message: "Categories reordered", Data: responseData})
+	hub.BroadcastExcept(client, "categoriesReordered", responseData)
+}
+
+// ArchiveCategoryHandler lets an admin archive a category so new posts can no
+// longer be put in it, or bring it back. Posts already in it keep it.
+func ArchiveCategoryHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req ArchiveCategoryRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permManageCategories)
+	if !ok {
+		return
+	}
+
+	var archivedAt interface{}
+	if req.Archived {
+		archivedAt = time.Now().UTC().Format(timestampFormat)
+	}
+	result, err := db.Exec("UPDATE categories SET archived_at = ? WHERE category_ID = ?", archivedAt, req.CategoryID)
+	if err != nil {
+		sendError(client, env, "Failed to archive category")
+		log.Println("Database error:", err)
+		return
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		sendError(client, env, "Category not found")
+		return
+	}
+	category, err := getCategory(db, req.CategoryID)
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	action := "restoreCategory"
+	if req.Archived {
+		action = "archiveCategory"
+	}
+	if err := logModeration(db, session.UserID, action, "category", category.CategoryID, category.Category); err != nil {
+		log.Println("Failed to write moderation log:", err)
+	}
+
+	responseData := map[string]interface{}{
+		"category": category,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "categoryArchived", ID: env.ID, Success: true, Message: "Category archive state changed", Data: responseData})
+	hub.BroadcastExcept(client, "categoryArchived", responseData)
+}
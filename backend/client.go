@@ -0,0 +1,93 @@
+package forum
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is how long a single write to a client may take
+	writeWait = 10 * time.Second
+
+	// sendBufferSize is how many outgoing messages may queue up for a client
+	// before it is considered too slow and dropped
+	sendBufferSize = 256
+)
+
+// Client is a WebSocket connection with its outbound queue. Only the
+// client's writePump goroutine writes to the connection; everyone else
+// queues messages with Send.
+type Client struct {
+	conn *websocket.Conn
+	send chan []byte
+
+	// done is closed when the client is dropped
+	done     chan struct{}
+	dropOnce sync.Once
+
+	// session is the user the connection is authenticated as, nil until
+	// login, and topics are the topics it subscribed to. Both are guarded
+	// by the hub's mutex.
+	session *Session
+	topics  map[string]struct{}
+}
+
+func newClient(conn *websocket.Conn) *Client {
+	return &Client{
+		conn:   conn,
+		send:   make(chan []byte, sendBufferSize),
+		done:   make(chan struct{}),
+		topics: make(map[string]struct{}),
+	}
+}
+
+// Send queues a JSON-encoded message for the client without blocking. A
+// client whose queue is full is dropped instead of holding up the sender.
+func (c *Client) Send(v interface{}) {
+	message, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Error encoding WebSocket message:", err)
+		return
+	}
+	c.sendRaw(message)
+}
+
+// sendRaw queues an already encoded message, see Send
+func (c *Client) sendRaw(message []byte) {
+	select {
+	case <-c.done:
+	case c.send <- message:
+	default:
+		log.Println("Dropping slow WebSocket client")
+		c.drop()
+	}
+}
+
+// drop closes the connection, which also ends the client's read loop
+func (c *Client) drop() {
+	c.dropOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// writePump writes queued messages to the connection until the client is dropped
+func (c *Client) writePump() {
+	for {
+		select {
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Println("Error writing to WebSocket connection:", err)
+				c.drop()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
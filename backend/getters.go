@@ -2,6 +2,7 @@ package forum
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -10,16 +11,25 @@ import (
 
 // CATEGORIES
 type Category struct {
-	CategoryID int    `json:"category_id"`
-	Category   string `json:"category"`
+	CategoryID  int    `json:"category_id"`
+	Category    string `json:"category"`
+	Description string `json:"description"`
+	Position    int    `json:"position"`
+	Archived    bool   `json:"archived"`
 }
 
+// GetCategories returns every category in the order admins gave them.
+// Archived categories are included so posts can still be filtered by them.
 func GetCategories(db *sql.DB) ([]Category, error) {
 	if db == nil {
 		return nil, errors.New("nil database connection")
 	}
 	var categories []Category
-	query := "SELECT category FROM categories"
+	query := `
+		SELECT category_ID, category, description, position, archived_at IS NOT NULL
+		FROM categories
+		ORDER BY position, category_ID
+	`
 	rows, err := db.Query(query)
 	if err != nil {
 		fmt.Println(err)
@@ -29,13 +39,13 @@ func GetCategories(db *sql.DB) ([]Category, error) {
 
 	for rows.Next() {
 		var category Category
-		err := rows.Scan(&category.Category)
+		err := rows.Scan(&category.CategoryID, &category.Category, &category.Description, &category.Position, &category.Archived)
 		if err != nil {
 			return nil, err
 		}
 		categories = append(categories, category)
 	}
-	return categories, nil
+	return categories, rows.Err()
 }
 
 // POSTS
@@ -47,85 +57,205 @@ type Post struct {
 	Categories   []Category `json:"categories"`
 	PostCategory string     `json:"post_category"`
 	CreatedAt    string     `json:"created_at"`
+	Edited       bool       `json:"edited"`
+	EditedAt     string     `json:"edited_at,omitempty"`
+	Deleted      bool       `json:"deleted"`
+	Locked       bool       `json:"locked"`
+	Likes        int        `json:"likes"`
+	Dislikes     int        `json:"dislikes"`
 }
 
-func GetAllPosts(db *sql.DB) ([]Post, error) {
-	var posts []Post
+// GetPostByID fetches a post, or a tombstone when it was deleted. A post
+// that does not exist gives sql.ErrNoRows.
+func GetPostByID(db *sql.DB, postID int) (Post, error) {
+	var post Post
 	query := `
-        SELECT p.post_ID, u.username, p.title, p.content, p.created_at,
-               c.category
+        SELECT p.post_ID, u.username, p.title, p.content, CAST(p.created_at AS TEXT), COALESCE(p.edited_at, ''),
+               p.deleted_at IS NOT NULL, p.locked_at IS NOT NULL, ` + reactionCounts("post_ID", "p.post_ID") + `
         FROM posts AS p
         INNER JOIN users AS u ON p.user_ID = u.user_ID
-        INNER JOIN post_categories AS pc ON p.post_ID = pc.post_ID
-        INNER JOIN categories AS c ON pc.category_ID = c.category_ID
-        GROUP BY p.post_ID, u.username, p.title, p.content, p.created_at
+        WHERE p.post_ID = ?
     `
-	rows, err := db.Query(query)
+	err := db.QueryRow(query, postID).Scan(
+		&post.PostID, &post.Username, &post.Title, &post.Content, &post.CreatedAt, &post.EditedAt,
+		&post.Deleted, &post.Locked, &post.Likes, &post.Dislikes,
+	)
 	if err != nil {
-		return nil, err
+		return Post{}, err
+	}
+	// Only a tombstone is left of a deleted post
+	if post.Deleted {
+		post.Title, post.Content = "", ""
+	}
+
+	// Fetch categories for the post
+	categories, err := GetCategoriesForPost(db, post.PostID)
+	if err != nil {
+		return Post{}, err
+	}
+	post.PostCategory = strings.Join(categories, " ") // Join the categories into a single string
+	post.Edited = post.EditedAt != ""
+
+	return post, nil
+}
+
+// FeedPost is a post as listed in the feed
+type FeedPost struct {
+	Post
+	CommentCount int `json:"comment_count"`
+}
+
+// FeedCursor is the position of a post in the feed. Posts are ordered by
+// (created_at, post_ID), so the cursor of the last post of a page is where
+// the next page starts.
+type FeedCursor struct {
+	CreatedAt string `json:"createdAt"`
+	PostID    int    `json:"postID"`
+}
+
+// FeedQuery selects a page of the feed
+type FeedQuery struct {
+	Limit    int
+	Cursor   *FeedCursor
+	Category string
+	Oldest   bool
+	// SubscriberID limits the feed to the categories this user subscribed to
+	SubscriberID int
+}
+
+// GetFeed fetches a page of posts, newest first unless q.Oldest is set,
+// starting after q.Cursor and limited to q.Category when it is not empty
+// and to the categories of q.SubscriberID when that is set.
+// The returned cursor is nil when there are no more posts.
+func GetFeed(db *sql.DB, q FeedQuery) ([]FeedPost, *FeedCursor, error) {
+	order, compare := "DESC", "<"
+	if q.Oldest {
+		order, compare = "ASC", ">"
+	}
+
+	conditions := []string{"p.deleted_at IS NULL"}
+	var args []interface{}
+	if q.Cursor != nil {
+		conditions = append(conditions, "(p.created_at, p.post_ID) "+compare+" (?, ?)")
+		args = append(args, q.Cursor.CreatedAt, q.Cursor.PostID)
+	}
+	if q.Category != "" {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM post_categories AS pc
+			INNER JOIN categories AS c ON pc.category_ID = c.category_ID
+			WHERE pc.post_ID = p.post_ID AND c.category = ?
+		)`)
+		args = append(args, q.Category)
+	}
+	if q.SubscriberID != 0 {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM post_categories AS pc
+			INNER JOIN category_subscriptions AS cs ON pc.category_ID = cs.category_ID
+			WHERE pc.post_ID = p.post_ID AND cs.user_ID = ?
+		)`)
+		args = append(args, q.SubscriberID)
+	}
+	where := "WHERE " + strings.Join(conditions, " AND ")
+
+	// created_at is read as plain text, so the cursor compares exactly like the stored value
+	query := `
+		SELECT p.post_ID, u.username, p.title, p.content, CAST(p.created_at AS TEXT), COALESCE(p.edited_at, ''), p.locked_at IS NOT NULL,
+		       (SELECT COUNT(*) FROM comments AS com WHERE com.post_ID = p.post_ID AND com.deleted_at IS NULL),
+		       ` + reactionCounts("post_ID", "p.post_ID") + `
+		FROM posts AS p
+		INNER JOIN users AS u ON p.user_ID = u.user_ID
+		` + where + `
+		ORDER BY p.created_at ` + order + `, p.post_ID ` + order + `
+		LIMIT ?
+	`
+	// One post more than the page holds tells whether there is a next page
+	args = append(args, q.Limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
 	}
 	defer rows.Close()
 
+	posts := make([]FeedPost, 0, q.Limit)
 	for rows.Next() {
-		var post Post
-		var category Category
+		var post FeedPost
 		err := rows.Scan(
-			&post.PostID, &post.Username, &post.Title, &post.Content, &post.CreatedAt,
-			&category.Category,
+			&post.PostID, &post.Username, &post.Title, &post.Content, &post.CreatedAt, &post.EditedAt, &post.Locked,
+			&post.CommentCount, &post.Likes, &post.Dislikes,
 		)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		post.Edited = post.EditedAt != ""
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
-		// Fetch categories for the current post
-		categories, err := GetCategoriesForPost(db, post.PostID)
+	var next *FeedCursor
+	if len(posts) > q.Limit {
+		posts = posts[:q.Limit]
+		last := posts[q.Limit-1]
+		next = &FeedCursor{CreatedAt: last.CreatedAt, PostID: last.PostID}
+	}
+
+	for i := range posts {
+		categories, err := GetCategoriesForPost(db, posts[i].PostID)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		post.PostCategory = strings.Join(categories, " ") // Join the categories into a single string
-
-		posts = append(posts, post)
+		posts[i].PostCategory = strings.Join(categories, " ")
 	}
-	return posts, nil
+
+	return posts, next, nil
 }
 
-func GetPostByID(db *sql.DB, postID int) (Post, error) {
-	var post Post
+// PostRevision is an earlier version of an edited post. WrittenAt is when
+// the version was posted and ReplacedAt when an edit replaced it.
+type PostRevision struct {
+	RevisionID int      `json:"revision_id"`
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	Categories []string `json:"categories"`
+	WrittenAt  string   `json:"written_at"`
+	ReplacedAt string   `json:"replaced_at"`
+}
+
+// GetPostRevisions fetches the earlier versions of a post, newest first
+func GetPostRevisions(db *sql.DB, postID int) ([]PostRevision, error) {
 	query := `
-        SELECT p.post_ID, u.username, p.title, p.content, p.created_at,
-               c.category
-        FROM posts AS p
-        INNER JOIN users AS u ON p.user_ID = u.user_ID
-        INNER JOIN post_categories AS pc ON p.post_ID = pc.post_ID
-        INNER JOIN categories AS c ON pc.category_ID = c.category_ID
-        WHERE p.post_ID = ?
-        GROUP BY p.post_ID, u.username, p.title, p.content, p.created_at
-    `
+		SELECT revision_ID, title, content, categories, written_at, replaced_at
+		FROM post_revisions
+		WHERE post_ID = ?
+		ORDER BY revision_ID DESC
+	`
 	rows, err := db.Query(query, postID)
 	if err != nil {
-		return Post{}, err
+		return nil, err
 	}
 	defer rows.Close()
 
+	revisions := make([]PostRevision, 0)
 	for rows.Next() {
-		var category Category
-		err := rows.Scan(
-			&post.PostID, &post.Username, &post.Title, &post.Content, &post.CreatedAt,
-			&category.Category,
-		)
+		var revision PostRevision
+		var categories string
+		err := rows.Scan(&revision.RevisionID, &revision.Title, &revision.Content, &categories, &revision.WrittenAt, &revision.ReplacedAt)
 		if err != nil {
-			return Post{}, err
+			return nil, err
 		}
-
-		// Fetch categories for the current post
-		categories, err := GetCategoriesForPost(db, post.PostID)
-		if err != nil {
-			return Post{}, err
+		if err := json.Unmarshal([]byte(categories), &revision.Categories); err != nil {
+			return nil, err
 		}
-		post.PostCategory = strings.Join(categories, " ") // Join the categories into a single string
+		revisions = append(revisions, revision)
 	}
 
-	return post, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
 }
 
 func GetCategoriesForPost(db *sql.DB, postID int) ([]string, error) {
@@ -135,6 +265,7 @@ func GetCategoriesForPost(db *sql.DB, postID int) ([]string, error) {
 		FROM categories AS c
 		INNER JOIN post_categories AS pc ON c.category_ID = pc.category_ID
 		WHERE pc.post_ID = ?
+		ORDER BY c.position, c.category_ID
 	`
 	rows, err := db.Query(query, postID)
 	if err != nil {
@@ -155,20 +286,97 @@ func GetCategoriesForPost(db *sql.DB, postID int) ([]string, error) {
 }
 
 type Comment struct {
-	CommentID int    `json:"comment_id"`
-	Username  string `json:"username"`
-	Content   string `json:"content"`
-	PostID    int    `json:"post_comment_id"`
+	CommentID  int        `json:"comment_id"`
+	Username   string     `json:"username"`
+	Content    string     `json:"content"`
+	PostID     int        `json:"post_comment_id"`
+	ParentID   int        `json:"parent_id,omitempty"`
+	Deleted    bool       `json:"deleted"`
+	Likes      int        `json:"likes"`
+	Dislikes   int        `json:"dislikes"`
+	ReplyCount int        `json:"reply_count,omitempty"`
+	Replies    []*Comment `json:"replies,omitempty"`
 }
 
-func GetAllComments(db *sql.DB) ([]Comment, error) {
+// GetCommentByID fetches a single comment
+func GetCommentByID(db *sql.DB, commentID int) (Comment, error) {
+	comments, err := queryComments(db, "com.comment_ID = ?", commentID)
+	if err != nil {
+		return Comment{}, err
+	}
+	if len(comments) == 0 {
+		return Comment{}, sql.ErrNoRows
+	}
+	return comments[0], nil
+}
+
+// GetCommentTree fetches the comments of a post as a tree of replies, down
+// to depth levels. Comments below that are left out, but their parents
+// still count them in ReplyCount.
+func GetCommentTree(db *sql.DB, postID, depth int) ([]*Comment, error) {
+	comments, err := queryComments(db, "com.post_ID = ?", postID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replies are written after their parents, so parents are always seen first
+	roots := make([]*Comment, 0)
+	nodes := make(map[int]*Comment)
+	depths := make(map[int]int)
+	for i := range comments {
+		comment := &comments[i]
+		parentDepth, known := depths[comment.ParentID]
+		if !known {
+			depths[comment.CommentID] = 1
+			nodes[comment.CommentID] = comment
+			roots = append(roots, comment)
+			continue
+		}
+
+		depths[comment.CommentID] = parentDepth + 1
+		parent := nodes[comment.ParentID]
+		if parent == nil {
+			// Below a comment that was already left out
+			continue
+		}
+		parent.ReplyCount++
+		if depths[comment.CommentID] <= depth {
+			nodes[comment.CommentID] = comment
+			parent.Replies = append(parent.Replies, comment)
+		}
+	}
+
+	return roots, nil
+}
+
+// getCommentDepth returns how deeply a comment is nested, 1 for a comment on the post itself
+func getCommentDepth(db *sql.DB, commentID int) (int, error) {
+	query := `
+		WITH RECURSIVE ancestors (comment_ID, parent_ID) AS (
+			SELECT comment_ID, parent_ID FROM comments WHERE comment_ID = ?
+			UNION ALL
+			SELECT c.comment_ID, c.parent_ID FROM comments AS c
+			INNER JOIN ancestors AS a ON c.comment_ID = a.parent_ID
+		)
+		SELECT COUNT(*) FROM ancestors
+	`
+	var depth int
+	err := db.QueryRow(query, commentID).Scan(&depth)
+	return depth, err
+}
+
+func queryComments(db *sql.DB, where string, args ...interface{}) ([]Comment, error) {
 	var comments []Comment
 	query := `
-		SELECT com.comment_ID, u.username, com.content, com.post_ID
+		SELECT com.comment_ID, u.username, com.content, com.post_ID, COALESCE(com.parent_ID, 0), com.deleted_at IS NOT NULL,
+		       ` + reactionCounts("comment_ID", "com.comment_ID") + `
 		FROM comments AS com
 		INNER JOIN users AS u ON com.user_ID = u.user_ID
+		INNER JOIN posts AS p ON com.post_ID = p.post_ID
+		WHERE ` + where + `
+		ORDER BY com.comment_ID
 	`
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -176,36 +384,89 @@ func GetAllComments(db *sql.DB) ([]Comment, error) {
 
 	for rows.Next() {
 		var comment Comment
-		err := rows.Scan(&comment.CommentID, &comment.Username, &comment.Content, &comment.PostID)
+		err := rows.Scan(&comment.CommentID, &comment.Username, &comment.Content, &comment.PostID, &comment.ParentID, &comment.Deleted, &comment.Likes, &comment.Dislikes)
 		if err != nil {
 			return nil, err
 		}
+		// Deleted comments stay in the thread as tombstones without author or text
+		if comment.Deleted {
+			comment.Username, comment.Content = "", ""
+		}
 		comments = append(comments, comment)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return comments, nil
 }
 
-// USERname
-func GetLoggedInUsername(sessionToken string, db *sql.DB) (string, error) {
-	// Use sessionToken in your SQL query or any other logic as needed
+// SESSIONS
+type Session struct {
+	ID       int
+	Token    string
+	UserID   int
+	Username string
+	Role     string
+}
+
+// GetSessionByToken returns the user owning an unexpired session token
+func GetSessionByToken(sessionToken string, db *sql.DB) (*Session, error) {
 	query := `
-        SELECT u.username
+        SELECT s.session_ID, s.token, u.user_ID, u.username, u.role
         FROM sessions s
         INNER JOIN users u ON s.user_ID = u.user_ID
-        WHERE s.token = ? AND s.expires_at > strftime('%s', 'now')
+        WHERE s.token = ? AND s.expires_at > ?
         LIMIT 1
     `
-	var username string
-	err := db.QueryRow(query, sessionToken).Scan(&username)
+	var session Session
+	err := db.QueryRow(query, sessionToken, time.Now().Unix()).Scan(&session.ID, &session.Token, &session.UserID, &session.Username, &session.Role)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SessionInfo describes one of a user's sessions without revealing its token
+type SessionInfo struct {
+	SessionID int    `json:"session_id"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	Current   bool   `json:"current"`
+}
+
+// GetSessionsForUser lists the active sessions of a user, newest first.
+// The session with currentID is flagged so the client can tell which one it is.
+func GetSessionsForUser(db *sql.DB, userID, currentID int) ([]SessionInfo, error) {
+	query := `
+        SELECT session_ID, user_agent, created_at, expires_at
+        FROM sessions
+        WHERE user_ID = ? AND expires_at > ?
+        ORDER BY created_at DESC
+    `
+	rows, err := db.Query(query, userID, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]SessionInfo, 0)
+	for rows.Next() {
+		var session SessionInfo
+		if err := rows.Scan(&session.SessionID, &session.UserAgent, &session.CreatedAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		session.Current = session.SessionID == currentID
+		sessions = append(sessions, session)
 	}
-	return username, nil
+	return sessions, rows.Err()
 }
+
 func GetAllUsernames(db *sql.DB) ([]string, error) {
 	var usernames []string
-	query := "SELECT username FROM users"
+	query := "SELECT username FROM users WHERE deleted_at IS NULL"
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
@@ -224,6 +485,23 @@ func GetAllUsernames(db *sql.DB) ([]string, error) {
 	return usernames, nil
 }
 
+// getUser returns the ID and the stored spelling of a username
+func getUser(username string, db *sql.DB) (int, string, error) {
+	query := "SELECT user_ID, username FROM users WHERE LOWER(username) = ? AND deleted_at IS NULL"
+	var userID int
+	var name string
+	err := db.QueryRow(query, strings.ToLower(username)).Scan(&userID, &name)
+	return userID, name, err
+}
+
+// getStoredUsername returns the stored spelling of a username, also for
+// deleted accounts, whose conversations stay readable by the other side
+func getStoredUsername(username string, db *sql.DB) (string, error) {
+	var name string
+	err := db.QueryRow("SELECT username FROM users WHERE LOWER(username) = ?", strings.ToLower(username)).Scan(&name)
+	return name, err
+}
+
 // getUserID retrieves the user ID based on the username
 func getUserID(username string, db *sql.DB) (int, error) {
 	query := "SELECT user_ID FROM users WHERE LOWER(username) = ?"
@@ -242,12 +520,12 @@ type OnlineUser struct {
 
 func GetAllOnlineUsers(db *sql.DB) ([]OnlineUser, error) {
 	query := `
-        SELECT u.user_ID, u.username
+        SELECT DISTINCT u.user_ID, u.username
         FROM users AS u
         JOIN sessions AS s ON u.user_ID = s.user_ID
-        WHERE s.expires_at > strftime('%s', 'now')  -- Check if session is still active
+        WHERE s.expires_at > ?  -- Check if session is still active
     `
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, time.Now().Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -267,18 +545,122 @@ func GetAllOnlineUsers(db *sql.DB) ([]OnlineUser, error) {
 }
 
 type Message struct {
-	ID        int       `json:"message_ID"`
-	Sender    string    `json:"sender"`
-	Receiver  string    `json:"receiver"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        int               `json:"message_ID"`
+	Sender    string            `json:"sender"`
+	Receiver  string            `json:"receiver"`
+	Content   string            `json:"content"`
+	CreatedAt time.Time         `json:"created_at"`
+	Reactions []MessageReaction `json:"reactions"`
 }
 
-// GetAllMessagesForUser fetches all messages associated with a user (either as a sender or receiver)
-func GetAllMessages(db *sql.DB) ([]Message, error) {
-	query := "SELECT message_ID, sender, receiver, content, created_at FROM private_messages ORDER BY created_at ASC"
+// MessageReaction is an emoji attached to a message and the users who
+// attached it, in the order they did
+type MessageReaction struct {
+	Emoji string   `json:"emoji"`
+	Users []string `json:"users"`
+}
 
-	rows, err := db.Query(query)
+// Conversation is the peer of one of a user's conversations and the time of
+// its latest message
+type Conversation struct {
+	Peer          string `json:"peer"`
+	LastMessageAt string `json:"lastMessageAt"`
+}
+
+// GetConversationsForUser lists everyone the user exchanged messages with
+func GetConversationsForUser(db *sql.DB, username string) ([]Conversation, error) {
+	query := `SELECT peer, MAX(created_at) FROM (
+		SELECT receiver AS peer, created_at FROM private_messages WHERE sender = ?
+		UNION ALL
+		SELECT sender AS peer, created_at FROM private_messages WHERE receiver = ?
+	) GROUP BY peer`
+
+	rows, err := db.Query(query, username, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := make([]Conversation, 0)
+	for rows.Next() {
+		var conversation Conversation
+		if err := rows.Scan(&conversation.Peer, &conversation.LastMessageAt); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return conversations, nil
+}
+
+// errUnknownMessage is returned for a before cursor that is not a message of
+// the conversation
+var errUnknownMessage = errors.New("Unknown message")
+
+// GetConversationPage fetches up to limit messages exchanged between two
+// users, oldest first. With before set to a message ID only messages older
+// than that message are returned, otherwise the latest ones. Each direction
+// of the conversation is read newest first from the conversation index, so
+// a page costs the same however long the chat log is.
+func GetConversationPage(db *sql.DB, username, peer string, before, limit int) ([]Message, error) {
+	cursor := ""
+	if before > 0 {
+		var known bool
+		err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM private_messages WHERE message_ID = ?1
+			AND ((sender = ?2 AND receiver = ?3) OR (sender = ?3 AND receiver = ?2)))`, before, username, peer).Scan(&known)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			return nil, errUnknownMessage
+		}
+		cursor = "AND (created_at, message_ID) < (SELECT created_at, message_ID FROM private_messages WHERE message_ID = :before)"
+	}
+	query := `SELECT message_ID, sender, receiver, content, created_at FROM (
+		SELECT * FROM (
+			SELECT message_ID, sender, receiver, content, created_at FROM private_messages
+			WHERE sender = :user AND receiver = :peer ` + cursor + `
+			ORDER BY created_at DESC, message_ID DESC LIMIT :limit
+		)
+		UNION ALL
+		SELECT * FROM (
+			SELECT message_ID, sender, receiver, content, created_at FROM private_messages
+			WHERE sender = :peer AND receiver = :user ` + cursor + `
+			ORDER BY created_at DESC, message_ID DESC LIMIT :limit
+		)
+		ORDER BY created_at DESC, message_ID DESC LIMIT :limit
+	) ORDER BY created_at ASC, message_ID ASC`
+
+	return queryMessages(db, query,
+		sql.Named("user", username),
+		sql.Named("peer", peer),
+		sql.Named("before", before),
+		sql.Named("limit", limit),
+	)
+}
+
+// GetMessageByID fetches a single message
+func GetMessageByID(db *sql.DB, messageID int64) (Message, error) {
+	var message Message
+	query := "SELECT message_ID, sender, receiver, content, created_at FROM private_messages WHERE message_ID = ?"
+	err := db.QueryRow(query, messageID).Scan(&message.ID, &message.Sender, &message.Receiver, &message.Content, &message.CreatedAt)
+	if err != nil {
+		return message, err
+	}
+	reactions, err := getMessageReactions(db, message.ID)
+	message.Reactions = reactions[message.ID]
+	if message.Reactions == nil {
+		message.Reactions = make([]MessageReaction, 0)
+	}
+	return message, err
+}
+
+func queryMessages(db *sql.DB, query string, args ...interface{}) ([]Message, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -299,5 +681,69 @@ func GetAllMessages(db *sql.DB) ([]Message, error) {
 		return nil, err
 	}
 
+	ids := make([]int, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	reactions, err := getMessageReactions(db, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+		if messages[i].Reactions == nil {
+			messages[i].Reactions = make([]MessageReaction, 0)
+		}
+	}
+
 	return messages, nil
 }
+
+// getMessageReactions fetches the reactions to the given messages, keyed by
+// message ID. Emojis are listed in the order they were first attached.
+func getMessageReactions(db *sql.DB, messageIDs ...int) (map[int][]MessageReaction, error) {
+	reactions := make(map[int][]MessageReaction)
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(messageIDs)), ", ")
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	query := `
+		SELECT mr.message_ID, mr.emoji, u.username
+		FROM message_reactions AS mr
+		INNER JOIN users AS u ON mr.user_ID = u.user_ID
+		WHERE mr.message_ID IN (` + placeholders + `)
+		ORDER BY mr.message_ID, mr.reaction_ID
+	`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var emoji, username string
+		if err := rows.Scan(&messageID, &emoji, &username); err != nil {
+			return nil, err
+		}
+
+		found := false
+		for i, reaction := range reactions[messageID] {
+			if reaction.Emoji == emoji {
+				reactions[messageID][i].Users = append(reaction.Users, username)
+				found = true
+				break
+			}
+		}
+		if !found {
+			reactions[messageID] = append(reactions[messageID], MessageReaction{Emoji: emoji, Users: []string{username}})
+		}
+	}
+
+	return reactions, rows.Err()
+}
@@ -4,24 +4,32 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// timestampFormat is how timestamps set by the server are stored, ISO 8601
+// in UTC like the timestamps the browser used to send
+const timestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
 type Response struct {
 	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 }
 
+// Responses to a request echo the request's ID so clients can match them up
 type SuccessResponse struct {
 	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
 	Success bool        `json:"success"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
@@ -35,249 +43,277 @@ type User struct {
 }
 
 // RegisterHandler handles user registration over WebSocket
-func RegisterHandler(conn *websocket.Conn, r *http.Request, db *sql.DB, message map[string]interface{}) {
+func RegisterHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
 	log.Println("RegisterHandler called.")
 
-	// Extract registration data from the message map
-	email, ok := message["email"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid email format"})
-		return
-	}
-
-	firstName, ok := message["first-name"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid first name format"})
-		return
-	}
-
-	lastName, ok := message["last-name"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid last name format"})
-		return
-	}
-
-	username, ok := message["username"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid username format"})
-		return
-	}
-
-	password, ok := message["password"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid password format"})
-		return
-	}
-
-	age, ok := message["age"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid age format"})
+	var req RegisterRequest
+	if !decodePayload(client, env, &req) {
 		return
 	}
-
-	gender, ok := message["gender"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid gender format"})
+	if !requireFields(client, env, "email", req.Email, "first-name", req.FirstName, "last-name", req.LastName,
+		"username", req.Username, "password", req.Password, "age", req.Age, "gender", req.Gender) {
 		return
 	}
 
 	// Convert email and username to lowercase
-	lowercaseEmail := strings.ToLower(email)
-	lowercaseUsername := strings.ToLower(username)
+	lowercaseEmail := strings.ToLower(req.Email)
+	lowercaseUsername := strings.ToLower(req.Username)
 
 	// Check if the user already exists in the database
 	var existingUser int
 	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE LOWER(email) = ? OR LOWER(username) = ?", lowercaseEmail, lowercaseUsername).Scan(&existingUser)
 	log.Printf("Query: SELECT COUNT(*) FROM users WHERE LOWER(email) = %s OR LOWER(username) = %s\n", lowercaseEmail, lowercaseUsername)
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Database error"})
+		sendError(client, env, "Database error")
 		log.Println("Database error:", err)
 		return
 	}
 	if existingUser > 0 {
 		log.Print("user already exists")
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "User already exists"})
+		sendError(client, env, "User already exists")
 		return
 	}
 
 	// Registration logic
 	createdAt := time.Now()
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Password hashing error"})
+		sendError(client, env, "Password hashing error")
 		log.Println("Password hashing error:", err)
 		return
 	}
 
 	// Continue with user registration
 	query := "INSERT INTO users (email, first_name, last_name, username, password, age, gender, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
-	_, err = db.Exec(query, lowercaseEmail, firstName, lastName, lowercaseUsername, hashedPassword, age, gender, createdAt)
+	_, err = db.Exec(query, lowercaseEmail, req.FirstName, req.LastName, lowercaseUsername, hashedPassword, req.Age, req.Gender, createdAt)
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Database error"})
+		sendError(client, env, "Database error")
 		log.Println("Database error:", err)
 		return
 	}
 
-	// Generate session token for the newly registered user
-	token := GenerateSessionToken()
-
-	// Calculate session duration
-	sessionDuration := time.Minute * 15
+	// No session is started here: the client logs in with the new
+	// credentials, which also sets the session cookie
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "Registration", ID: env.ID, Success: true, Message: "Registration successful", Data: map[string]interface{}{
+		"username": lowercaseUsername,
+	}})
+}
 
-	// Calculate expiration time
-	expirationTime := time.Now().Add(sessionDuration)
+// LoginHandler handles user login over WebSocket
+func LoginHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	log.Println("LoginHandler called.")
+	var req LoginRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	if !requireFields(client, env, "identifier", req.Identifier, "password", req.Password) {
+		return
+	}
 
-	// Get the user ID of the newly registered user
-	userID, err := getUserID(lowercaseUsername, db)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Failed to get user ID"})
-		log.Println("Failed to get user ID:", err)
+	user, err := checkCredentials(req.Identifier, req.Password, db)
+	var ban *Ban
+	if err == errUserNotFound || err == errInvalidPassword || errors.As(err, &ban) {
+		sendError(client, env, err.Error())
+		return
+	} else if err != nil {
+		log.Println("Database error while retrieving user:", err)
+		sendError(client, env, "Database error")
 		return
 	}
 
 	// Create a new session record in the database
-	err = createSession(conn, userID, token, expirationTime, db)
+	session, err := startSession(user.ID, r.UserAgent(), db)
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Database error"})
+		sendError(client, env, "Database error")
 		log.Println("Database error:", err)
 		return
 	}
 
-	loggedInUsername, err := GetLoggedInUsername(token, db)
+	sendLoginData(client, db, env, session, "Login", "Login successful")
+}
+
+var errUserNotFound = errors.New("User not found")
+var errInvalidPassword = errors.New("Invalid password")
+
+// checkCredentials looks up a user by email or username and verifies the
+// password. Banned and suspended users get their *Ban as the error.
+func checkCredentials(identifier, password string, db *sql.DB) (User, error) {
+	lowercaseIdentifier := strings.ToLower(identifier)
+
+	// Use the provided identifier to retrieve user from the database
+	var user User
+	query := "SELECT user_ID, email, username, password FROM users WHERE (LOWER(email) = ? OR LOWER(username) = ?) AND deleted_at IS NULL"
+	err := db.QueryRow(query, lowercaseIdentifier, lowercaseIdentifier).Scan(&user.ID, &user.Email, &user.Username, &user.Password)
+	if err == sql.ErrNoRows {
+		return User{}, errUserNotFound
+	} else if err != nil {
+		return User{}, err
+	}
+
+	// Check the password
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return User{}, errInvalidPassword
+	}
+
+	ban, err := getActiveBan(db, user.ID)
+	if err != nil {
+		return User{}, err
+	}
+	if ban != nil {
+		return User{}, ban
+	}
+	return user, nil
+}
+
+// startSession creates a new session record for the user on the device
+// identified by userAgent
+func startSession(userID int, userAgent string, db *sql.DB) (*Session, error) {
+	token := GenerateSessionToken()
+
+	// Calculate expiration time
+	expirationTime := time.Now().Add(SessionLifetime)
+
+	err := createSession(userID, token, userAgent, expirationTime, db)
+	if err != nil {
+		return nil, err
+	}
+	return GetSessionByToken(token, db)
+}
+
+// sendLoginData binds the session to the connection and sends the data the
+// frontend needs right after logging in
+func sendLoginData(client *Client, db *sql.DB, env Envelope, session *Session, responseType, message string) {
+	// Every further action on this connection is performed as this user
+	bindSession(client, session)
+
+	// Fetch who the logged-in user chatted with, the messages themselves
+	// are loaded a page at a time with getConversation
+	conversations, err := GetConversationsForUser(db, session.Username)
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Failed to get logged-in username"})
+		sendError(client, env, "Failed to fetch conversations")
+		log.Println("Failed to fetch conversations:", err)
 		return
 	}
-	isAuthenticated := true
 
-	// Fetch all messages associated with the logged-in user
-	allMessages, err := GetAllMessages(db)
+	subscriptions, err := getCategorySubscriptions(db, session.UserID)
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Failed to fetch messages"})
-		log.Println("Failed to fetch messages:", err)
+		sendError(client, env, "Failed to fetch subscriptions")
+		log.Println("Failed to fetch subscriptions:", err)
 		return
 	}
 
 	// Prepare data to be sent over WebSocket
 	responseData := map[string]interface{}{
-		"loggedInUsername": loggedInUsername,
-		"isAuthenticated":  isAuthenticated,
-		"allMessages":      allMessages,
+		"loggedInUsername": session.Username,
+		"role":             session.Role,
+		"isAuthenticated":  true,
+		"conversations":    conversations,
+		"subscriptions":    subscriptions,
 	}
 
 	// Send data over WebSocket
-	SendWebSocketMessageSuccess(conn, SuccessResponse{Type: "Registration", Success: true, Message: "Registration successful", Data: responseData})
-
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: responseType, ID: env.ID, Success: true, Message: message, Data: responseData})
 }
 
-// LoginHandler handles user login over WebSocket
-func LoginHandler(conn *websocket.Conn, r *http.Request, db *sql.DB, message map[string]interface{}) {
-	log.Println("LoginHandler called.")
-	// Ensure that the WebSocket message contains the necessary fields
-	identifier, ok := message["identifier"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format"})
+// HomePageHandler sends what the home page shows besides the posts, which
+// are loaded a page at a time with getFeed
+func HomePageHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	if _, ok := requirePermission(client, db, env, permRead); !ok {
 		return
 	}
-
-	password, ok := message["password"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format"})
+	// Get needed data for the home page
+	allUsernames, err := GetAllUsernames(db)
+	if err != nil {
+		sendError(client, env, "Failed to get all usernames")
 		return
 	}
-
-	lowercaseIdentifier := strings.ToLower(identifier)
-
-	var existingUser int
-	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE LOWER(email) = ? OR LOWER(username) = ?", lowercaseIdentifier, lowercaseIdentifier).Scan(&existingUser)
+	allCategories, err := GetCategories(db)
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Database error"})
-		log.Println("Database error:", err)
+		sendError(client, env, "Failed to get all categories")
 		return
 	}
-
-	// Check for an existing user
-	if existingUser == 0 {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "User not found"})
+	usersOnline, err := GetAllOnlineUsers(db)
+	if err != nil {
+		sendError(client, env, "Failed to get all online users")
 		return
 	}
-
-	// Use the provided identifier to retrieve user from the database
-	var user User
-	query := "SELECT user_ID, email, username, password FROM users WHERE LOWER(email) = ? OR LOWER(username) = ?"
-	err = db.QueryRow(query, lowercaseIdentifier, lowercaseIdentifier).Scan(&user.ID, &user.Email, &user.Username, &user.Password)
-
-	if err == sql.ErrNoRows {
-		log.Println("User not found in the database")
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "User not found"})
-		return
-	} else if err != nil {
-		log.Println("Database error while retrieving user:", err)
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Database error"})
-		return
+	// Send the data back to the frontend
+	responseData := struct {
+		AllUsernames   []string     `json:"allUsernames"`
+		AllCategories  []Category   `json:"allCategories"`
+		AllUsersOnline []OnlineUser `json:"usersOnline"`
+	}{
+		AllUsernames:   allUsernames,
+		AllCategories:  allCategories,
+		AllUsersOnline: usersOnline,
 	}
 
-	// Check the password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid password"})
-		return
-	}
-	token := GenerateSessionToken()
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "allData", ID: env.ID, Success: true, Message: "Home Page Data", Data: responseData})
+}
 
-	// Calculate session duration
-	sessionDuration := time.Minute * 15
+const (
+	// defaultFeedPage is how many posts getFeed returns when the request does not say
+	defaultFeedPage = 20
 
-	// Calculate expiration time
-	expirationTime := time.Now().Add(sessionDuration)
+	// maxFeedPage caps the limit a client may ask for
+	maxFeedPage = 50
+)
 
-	// Create a new session record in the database
-	err = createSession(conn, user.ID, token, expirationTime, db)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Database error"})
-		log.Println("Database error:", err)
+// FeedHandler sends a page of the post feed, see GetFeed
+func FeedHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req FeedRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permRead)
+	if !ok {
 		return
 	}
 
-	loggedInUsername, err := GetLoggedInUsername(token, db)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Failed to get logged-in username"})
+	q := FeedQuery{Limit: req.Limit, Cursor: req.Cursor, Category: req.Category}
+	if req.Subscribed {
+		q.SubscriberID = session.UserID
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultFeedPage
+	} else if q.Limit > maxFeedPage {
+		q.Limit = maxFeedPage
+	}
+	switch req.Sort {
+	case "", "newest":
+		req.Sort = "newest"
+	case "oldest":
+		q.Oldest = true
+	default:
+		sendError(client, env, fmt.Sprintf("Invalid getFeed payload: unknown sort %q", req.Sort))
 		return
 	}
-	isAuthenticated := true
 
-	// Fetch all messages associated with the logged-in user
-	allMessages, err := GetAllMessages(db)
+	posts, next, err := GetFeed(db, q)
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Failed to fetch messages"})
-		log.Println("Failed to fetch messages:", err)
+		sendError(client, env, "Failed to get posts")
+		log.Println("Failed to get feed:", err)
 		return
 	}
 
-	// Prepare data to be sent over WebSocket
 	responseData := map[string]interface{}{
-		"loggedInUsername": loggedInUsername,
-		"isAuthenticated":  isAuthenticated,
-		"allMessages":      allMessages,
+		"posts":      posts,
+		"nextCursor": next,
+		"category":   req.Category,
+		"sort":       req.Sort,
+		"subscribed": req.Subscribed,
+		"continued":  req.Cursor != nil,
 	}
-
-	// Send data over WebSocket
-	SendWebSocketMessageSuccess(conn, SuccessResponse{Type: "Login", Success: true, Message: "Login successful", Data: responseData})
-
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "feed", ID: env.ID, Success: true, Message: "Feed", Data: responseData})
 }
 
-// SendWebSocketMessage sends a JSON-encoded message to the WebSocket client
-func SendWebSocketMessage(conn *websocket.Conn, response Response) {
-	err := conn.WriteJSON(response)
-	if err != nil {
-		log.Println("Error writing JSON to WebSocket connection:", err)
-	}
+// SendWebSocketMessage queues a JSON-encoded message for the WebSocket client
+func SendWebSocketMessage(client *Client, response Response) {
+	client.Send(response)
 }
 
-func SendWebSocketMessageSuccess(conn *websocket.Conn, response SuccessResponse) {
-	err := conn.WriteJSON(response)
-	if err != nil {
-		log.Println("Error writing JSON to WebSocket connection:", err)
-	}
+func SendWebSocketMessageSuccess(client *Client, response SuccessResponse) {
+	client.Send(response)
 }
 
 func GenerateSessionToken() string {
@@ -286,56 +322,32 @@ func GenerateSessionToken() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-func createSession(conn *websocket.Conn, userID int, token string, expirationTime time.Time, db *sql.DB) error {
+// createSession stores a new session. Every login gets its own session so a
+// user can stay logged in on several devices at once.
+func createSession(userID int, token, userAgent string, expirationTime time.Time, db *sql.DB) error {
 	log.Print("Trying to create session")
 
-	// Delete expired sessions before creating a new one
-	err := DeleteExpiredSessions(db)
+	insertQuery := "INSERT INTO sessions (token, user_ID, created_at, expires_at, user_agent) VALUES (?, ?, ?, ?, ?)"
+	_, err := db.Exec(insertQuery, token, userID, time.Now(), expirationTime.Unix(), userAgent)
 	if err != nil {
-		log.Println("Error deleting expired sessions:", err)
-		return err
-	}
-
-	// Check if an active session already exists for the user
-	var existingSessionID int
-	query := "SELECT session_ID FROM sessions WHERE user_ID = ? AND expires_at > ?"
-	err = db.QueryRow(query, userID, time.Now()).Scan(&existingSessionID)
-
-	if err == sql.ErrNoRows {
-		// No active session found, create a new one
-		insertQuery := "INSERT INTO sessions (token, user_ID, created_at, expires_at) VALUES (?, ?, ?, ?)"
-		_, err = db.Exec(insertQuery, token, userID, time.Now(), expirationTime)
-
-		if err != nil {
-			log.Println("Error creating a new session:", err)
-			return err
-		}
-		log.Println("New session created successfully")
-	} else if err != nil {
-		log.Println("Error checking for an existing session:", err)
+		log.Println("Error creating a new session:", err)
 		return err
-	} else {
-		// Update the existing session with new token and expiration time
-		updateQuery := "UPDATE sessions SET token = ?, expires_at = ? WHERE session_ID = ?"
-		_, err = db.Exec(updateQuery, token, expirationTime, existingSessionID)
-
-		if err != nil {
-			log.Println("Error updating an existing session:", err)
-			return err
-		}
-
-		log.Println("Session updated successfully")
 	}
+	log.Println("New session created successfully")
 	return nil
 }
 
-func DeleteExpiredSessions(db *sql.DB) error {
+// DeleteExpiredSessions removes expired sessions and reports how many were removed
+func DeleteExpiredSessions(db *sql.DB) (int64, error) {
 	deleteQuery := "DELETE FROM sessions WHERE expires_at <= ?"
-	_, err := db.Exec(deleteQuery, time.Now())
-	return err
+	result, err := db.Exec(deleteQuery, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-func NotifyAllUsersOnlineStatus(conn *websocket.Conn, userID int, db *sql.DB) error {
+func NotifyAllUsersOnlineStatus(client *Client, userID int, db *sql.DB) error {
 	log.Print("Notifying all users about online status change")
 
 	// Fetch online users
@@ -352,261 +364,948 @@ func NotifyAllUsersOnlineStatus(conn *websocket.Conn, userID int, db *sql.DB) er
 		UsersOnlineStatus: onlineUsers,
 	}
 
-	SendWebSocketMessageSuccess(conn, SuccessResponse{
+	SendWebSocketMessageSuccess(client, SuccessResponse{
 		Type:    "onlineStatusChange",
 		Success: true,
 		Message: "Update Users Online Status",
 		Data:    responseData,
 	})
-	BroadcastChanges(conn, "updatAllUsersOnline", responseData)
+	hub.Broadcast("updatAllUsersOnline", responseData)
 
 	return nil
 }
 
 // CreatePostHandler handles post creation over WebSocket
-func CreatePostHandler(conn *websocket.Conn, r *http.Request, db *sql.DB, message map[string]interface{}) {
+func CreatePostHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
 	log.Println("CreatePostHandler called.")
 
-	// Ensure that the WebSocket message contains the necessary fields
-	createdBy, ok := message["createdBy"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format lol"})
+	var req CreatePostRequest
+	if !decodePayload(client, env, &req) {
 		return
 	}
-
-	title, ok := message["title"].(string)
+	session, ok := requirePermission(client, db, env, permPost)
 	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format kek"})
 		return
 	}
-
-	content, ok := message["content"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format haha"})
+	if !checkIdentity(client, env, session, req.CreatedBy) {
 		return
 	}
-
-	// Type assert the field to []interface{}
-	categoriesInterface, ok := message["categories"].([]interface{})
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid type for categories field"})
+	if !requireFields(client, env, "title", req.Title, "content", req.Content) {
 		return
 	}
-
-	// Convert each interface{} element to string
-	var categories []string
-	for _, cat := range categoriesInterface {
-		category, ok := cat.(string)
-		if !ok {
-			SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid type for category element"})
-			return
-		}
-		categories = append(categories, category)
+	// New posts reach their readers through their categories
+	if len(req.Categories) == 0 {
+		sendError(client, env, "Invalid createPost payload: missing categories")
+		return
 	}
 
-	// Insert the new post into the database
-	userID, err := getUserID(createdBy, db)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Database error"})
+	categoryIDs, err := getCategoryIDs(req.Categories, 0, db)
+	if err == errUnknownCategory || err == errArchivedCategory {
+		sendError(client, env, err.Error())
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
 		log.Println("Database error:", err)
 		return
 	}
-	createdAt := time.Now()
+
+	createdAt := time.Now().UTC().Format(timestampFormat)
 	// Insert the new post into the database
-	err = createPost(userID, title, content, categories, createdAt, db)
+	postID, err := createPost(session.UserID, req.Title, req.Content, categoryIDs, createdAt, db)
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Database error"})
+		sendError(client, env, "Database error")
 		log.Println("Database error:", err)
 		return
 	}
-
-	// Prepare data to be sent over WebSocket
-	allPosts, err := GetAllPosts(db)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Failed to get all posts"})
+	post, err := GetPostByID(db, postID)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "Post not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Failed to get post")
+		log.Println("Failed to get post:", err)
 		return
-	}
-	// Send the data back to the frontend
-	responseData := struct {
-		AllPosts []Post `json:"allPosts"`
-	}{
-		AllPosts: allPosts,
 	}
 
-	SendWebSocketMessageSuccess(conn, SuccessResponse{Type: "createdPost", Success: true, Message: "Update Posts Data", Data: responseData})
-	BroadcastChanges(conn, "updateAllPosts", responseData)
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "createdPost", ID: env.ID, Success: true, Message: "Post created", Data: map[string]interface{}{
+		"post": post,
+	}})
+	if err := notifyNewPost(db, post, categoryIDs, session.UserID); err != nil {
+		log.Println("Failed to notify subscribers:", err)
+	}
 }
 
-// Function to insert a new post into the database
-func createPost(userID int, title, content string, categories []string, createdAt time.Time, db *sql.DB) error {
+// Function to insert a new post into the database, returning its ID
+func createPost(userID int, title, content string, categoryIDs []int, createdAt string, db *sql.DB) (int, error) {
 	// Insert the post into the posts table
-	_, err := db.Exec("INSERT INTO posts (user_ID, title, content, created_at) VALUES (?, ?, ?, ?)", userID, title, content, createdAt)
+	result, err := db.Exec("INSERT INTO posts (user_ID, title, content, created_at) VALUES (?, ?, ?, ?)", userID, title, content, createdAt)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	postID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
 	}
 
 	// Associate the post with categories in the post_categories table
-	for _, category := range categories {
-		_, err := db.Exec("INSERT INTO post_categories (post_ID, category_ID) VALUES ((SELECT post_ID FROM posts WHERE user_ID = ? AND title = ? AND content = ? AND created_at = ?), (SELECT category_ID FROM categories WHERE category = ?))", userID, title, content, createdAt, category)
+	for _, categoryID := range categoryIDs {
+		_, err := db.Exec("INSERT INTO post_categories (post_ID, category_ID) VALUES (?, ?)", postID, categoryID)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
-	return nil
+	return int(postID), nil
 }
 
-// SubmitCommentHandler handles comment submission over WebSocket
-func SubmitCommentHandler(conn *websocket.Conn, r *http.Request, db *sql.DB, message map[string]interface{}) {
-	log.Println("Submit Comment Handler called.")
-
-	username, ok := message["username"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format lol"})
+// EditPostHandler lets the author of a post change its title, content and
+// categories. The replaced version is kept as a revision.
+func EditPostHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req EditPostRequest
+	if !decodePayload(client, env, &req) {
 		return
 	}
-
-	comment, ok := message["comment"].(string)
+	session, ok := requirePermission(client, db, env, permPost)
 	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format um"})
 		return
 	}
-
-	postIDStr, ok := message["postID"].(string)
-	if !ok {
-		// Handle the case where postID is not a string
-		log.Println("postID is not a string")
+	if !requireFields(client, env, "title", req.Title, "content", req.Content) {
+		return
+	}
+	if len(req.Categories) == 0 {
+		sendError(client, env, "Invalid editPost payload: missing categories")
 		return
 	}
 
-	postID, err := strconv.Atoi(postIDStr)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format ok"})
-		log.Println("Invalid postID:", postIDStr)
+	authorID, err := getPostAuthor(req.PostID, db)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "Post not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	if authorID != session.UserID {
+		sendError(client, env, "Only the author can edit this post")
 		return
 	}
 
-	userID, err := getUserID(username, db)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format sure"})
+	categoryIDs, err := getCategoryIDs(req.Categories, req.PostID, db)
+	if err == errUnknownCategory || err == errArchivedCategory {
+		sendError(client, env, err.Error())
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
 		log.Println("Database error:", err)
 		return
 	}
 
-	_, err = db.Exec("INSERT INTO comments (post_ID, user_ID, content, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", postID, userID, comment)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format lol"})
+	editedAt := time.Now().UTC().Format(timestampFormat)
+	if err := editPost(req.PostID, req.Title, req.Content, categoryIDs, editedAt, db); err != nil {
+		sendError(client, env, "Failed to edit post")
 		log.Println("Database error:", err)
 		return
 	}
 
-	// Prepare data to be sent over WebSocket
-	allComments, err := GetAllComments(db)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Success: false, Message: "Failed to get all posts"})
+	post, err := GetPostByID(db, req.PostID)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "Post not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Failed to get post")
+		log.Println("Failed to get post:", err)
 		return
 	}
-	// Send the data back to the frontend
-	responseData := struct {
-		AllComments []Comment `json:"allComments"`
-	}{
-		AllComments: allComments,
+
+	responseData := map[string]interface{}{
+		"post": post,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "postEdited", ID: env.ID, Success: true, Message: "Post edited", Data: responseData})
+	if err := notifyPostChange(db, client, req.PostID, "postEdited", responseData); err != nil {
+		log.Println("Failed to notify post change:", err)
 	}
+}
 
-	SendWebSocketMessageSuccess(conn, SuccessResponse{Type: "newComment", Success: true, Message: "Update Posts Data", Data: responseData})
-	BroadcastChanges(conn, "updateAllComments", responseData)
+// getPostAuthor returns the ID of the user who wrote a post that has not
+// been deleted, or sql.ErrNoRows
+func getPostAuthor(postID int, db *sql.DB) (int, error) {
+	var authorID int
+	err := db.QueryRow("SELECT user_ID FROM posts WHERE post_ID = ? AND deleted_at IS NULL", postID).Scan(&authorID)
+	return authorID, err
 }
 
-// DeleteSessionHandler handles session deletion over WebSocket
-func DeleteSessionHandler(conn *websocket.Conn, r *http.Request, db *sql.DB, message map[string]interface{}) {
-	log.Println("Delete Session Handler called.")
+// getCommentPost returns the ID of the post a comment belongs to, or
+// sql.ErrNoRows when the comment or its post has been deleted
+func getCommentPost(commentID int, db *sql.DB) (int, error) {
+	var postID int
+	err := db.QueryRow(`
+		SELECT com.post_ID
+		FROM comments AS com
+		INNER JOIN posts AS p ON com.post_ID = p.post_ID
+		WHERE com.comment_ID = ? AND com.deleted_at IS NULL AND p.deleted_at IS NULL
+	`, commentID).Scan(&postID)
+	return postID, err
+}
 
-	username, ok := message["username"].(string)
+// canDelete reports whether the session's user may delete content written by
+// authorID: their own, or anyone's for moderators
+func canDelete(session *Session, authorID int) bool {
+	return session.UserID == authorID || hasPermission(session.Role, permModerate)
+}
+
+// DeletePostHandler soft-deletes a post together with its comments. The rows
+// are kept so a tombstone can be shown in their place.
+func DeletePostHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req PostRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permPost)
 	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format"})
 		return
 	}
 
-	userID, err := getUserID(username, db)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format"})
+	authorID, err := getPostAuthor(req.PostID, db)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "Post not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
 		log.Println("Database error:", err)
 		return
 	}
+	if !canDelete(session, authorID) {
+		sendError(client, env, "Only the author or a moderator can delete this post")
+		return
+	}
 
-	// Delete the session for the given user
-	_, err = db.Exec("DELETE FROM sessions WHERE user_ID = ?", userID)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Failed to delete session"})
+	if err := deletePost(req.PostID, time.Now().UTC().Format(timestampFormat), db); err != nil {
+		sendError(client, env, "Failed to delete post")
 		log.Println("Database error:", err)
 		return
 	}
-	usersOnline, err := GetAllOnlineUsers(db)
+	// Moderators deleting someone else's post leave a trace in the audit trail
+	if authorID != session.UserID {
+		if err := logModeration(db, session.UserID, "deletePost", "post", req.PostID, ""); err != nil {
+			log.Println("Failed to write moderation log:", err)
+		}
+	}
+
+	responseData := map[string]interface{}{
+		"postID": req.PostID,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "postDeleted", ID: env.ID, Success: true, Message: "Post deleted", Data: responseData})
+	if err := notifyPostChange(db, client, req.PostID, "postDeleted", responseData); err != nil {
+		log.Println("Failed to notify post change:", err)
+	}
+}
+
+// deletePost marks a post and its comments as deleted
+func deletePost(postID int, deletedAt string, db *sql.DB) error {
+	tx, err := db.Begin()
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Success: false, Message: "Failed to get all online users"})
-		return
+		return err
 	}
-	// Send the data back to the frontend
-	responseData := struct {
-		AllUsersOnline []OnlineUser `json:"usersOnline"`
-	}{
-		AllUsersOnline: usersOnline,
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE posts SET deleted_at = ? WHERE post_ID = ?", deletedAt, postID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE comments SET deleted_at = ? WHERE post_ID = ? AND deleted_at IS NULL", deletedAt, postID); err != nil {
+		return err
 	}
 
-	// Send a success message back to the frontend
-	SendWebSocketMessageSuccess(conn, SuccessResponse{Type: "userLogout", Success: true, Message: "User logout successful"})
-	BroadcastChanges(conn, "updatAllUsersOnline", responseData)
+	return tx.Commit()
 }
 
-// SubmitMessageHandler handles message submission over WebSocket
-func SubmitMessageHandler(conn *websocket.Conn, r *http.Request, db *sql.DB, message map[string]interface{}) {
-	log.Println("Submit Message Handler called.")
-
-	sender, ok := message["sender"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format: sender"})
+// DeleteCommentHandler soft-deletes a comment, leaving a tombstone in its thread
+func DeleteCommentHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req CommentRequest
+	if !decodePayload(client, env, &req) {
 		return
 	}
-
-	receiver, ok := message["receiver"].(string)
+	session, ok := requirePermission(client, db, env, permComment)
 	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format: receiver"})
 		return
 	}
 
-	content, ok := message["content"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format: content"})
+	var authorID, postID int
+	err := db.QueryRow(`
+		SELECT com.user_ID, com.post_ID
+		FROM comments AS com
+		INNER JOIN posts AS p ON com.post_ID = p.post_ID
+		WHERE com.comment_ID = ? AND com.deleted_at IS NULL AND p.deleted_at IS NULL
+	`, req.CommentID).Scan(&authorID, &postID)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "Comment not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
 		return
 	}
-
-	created_at, ok := message["created_at"].(string)
-	if !ok {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Invalid message format: created_at"})
+	if !canDelete(session, authorID) {
+		sendError(client, env, "Only the author or a moderator can delete this comment")
 		return
 	}
 
-	_, err := db.Exec("INSERT INTO private_messages (sender, receiver, content, created_at) VALUES (?, ?, ?, ?)", sender, receiver, content, created_at)
+	_, err = db.Exec("UPDATE comments SET deleted_at = ? WHERE comment_ID = ?", time.Now().UTC().Format(timestampFormat), req.CommentID)
 	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Failed to insert message into the database"})
+		sendError(client, env, "Failed to delete comment")
 		log.Println("Database error:", err)
 		return
 	}
+	if authorID != session.UserID {
+		if err := logModeration(db, session.UserID, "deleteComment", "comment", req.CommentID, ""); err != nil {
+			log.Println("Failed to write moderation log:", err)
+		}
+	}
 
-	// Fetch all messages associated with the sender
-	allMessages, err := GetAllMessages(db)
-	if err != nil {
-		SendWebSocketMessage(conn, Response{Type: "Error", Success: false, Message: "Failed to fetch messages"})
-		log.Println("Failed to fetch messages:", err)
+	responseData := map[string]interface{}{
+		"postID":    postID,
+		"commentID": req.CommentID,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "commentDeleted", ID: env.ID, Success: true, Message: "Comment deleted", Data: responseData})
+	// The feed shows how many comments a post has, so the post's categories hear of it too
+	if err := notifyPostChange(db, client, postID, "commentDeleted", responseData); err != nil {
+		log.Println("Failed to notify post change:", err)
+	}
+}
+
+// LockPostHandler lets a moderator lock a post against new comments, or
+// unlock it again
+func LockPostHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req LockPostRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permModerate)
+	if !ok {
+		return
+	}
+
+	var lockedAt interface{}
+	if req.Locked {
+		lockedAt = time.Now().UTC().Format(timestampFormat)
+	}
+	result, err := db.Exec("UPDATE posts SET locked_at = ? WHERE post_ID = ? AND deleted_at IS NULL", lockedAt, req.PostID)
+	if err != nil {
+		sendError(client, env, "Failed to lock post")
+		log.Println("Database error:", err)
+		return
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		sendError(client, env, "Post not found")
+		return
+	}
+
+	action := "unlockPost"
+	if req.Locked {
+		action = "lockPost"
+	}
+	if err := logModeration(db, session.UserID, action, "post", req.PostID, ""); err != nil {
+		log.Println("Failed to write moderation log:", err)
+	}
+
+	responseData := map[string]interface{}{
+		"postID": req.PostID,
+		"locked": req.Locked,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "postLocked", ID: env.ID, Success: true, Message: "Post lock changed", Data: responseData})
+	if err := notifyPostChange(db, client, req.PostID, "postLocked", responseData); err != nil {
+		log.Println("Failed to notify post change:", err)
+	}
+}
+
+var (
+	errUnknownCategory  = errors.New("Unknown category")
+	errArchivedCategory = errors.New("Category is archived")
+)
+
+// getCategoryIDs looks up the IDs of the named categories, each one once.
+// Archived categories can no longer be picked, except by the post postID
+// that is already in them; new posts pass 0.
+func getCategoryIDs(names []string, postID int, db *sql.DB) ([]int, error) {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, name := range names {
+		var id int
+		var archived bool
+		err := db.QueryRow(`
+			SELECT c.category_ID, c.archived_at IS NOT NULL AND NOT EXISTS (
+				SELECT 1 FROM post_categories AS pc WHERE pc.post_ID = ? AND pc.category_ID = c.category_ID
+			)
+			FROM categories AS c
+			WHERE c.category = ?
+		`, postID, name).Scan(&id, &archived)
+		if err == sql.ErrNoRows {
+			return nil, errUnknownCategory
+		} else if err != nil {
+			return nil, err
+		}
+		if archived {
+			return nil, errArchivedCategory
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// editPost replaces a post's title, content and categories and keeps the
+// replaced version in post_revisions
+func editPost(postID int, title, content string, categoryIDs []int, editedAt string, db *sql.DB) error {
+	categories, err := GetCategoriesForPost(db, postID)
+	if err != nil {
+		return err
+	}
+	oldCategories, err := json.Marshal(categories)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The replaced version was written when the post was created or last edited
+	_, err = tx.Exec(`
+		INSERT INTO post_revisions (post_ID, title, content, categories, written_at, replaced_at)
+		SELECT post_ID, title, content, ?, COALESCE(edited_at, CAST(created_at AS TEXT)), ?
+		FROM posts WHERE post_ID = ?
+	`, string(oldCategories), editedAt, postID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE posts SET title = ?, content = ?, edited_at = ? WHERE post_ID = ?", title, content, editedAt, postID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM post_categories WHERE post_ID = ?", postID); err != nil {
+		return err
+	}
+	for _, categoryID := range categoryIDs {
+		if _, err := tx.Exec("INSERT INTO post_categories (post_ID, category_ID) VALUES (?, ?)", postID, categoryID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PostRevisionsHandler sends the earlier versions of a post
+func PostRevisionsHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req PostRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	if _, ok := requirePermission(client, db, env, permRead); !ok {
+		return
+	}
+	if _, err := getPostAuthor(req.PostID, db); err == sql.ErrNoRows {
+		sendError(client, env, "Post not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	revisions, err := GetPostRevisions(db, req.PostID)
+	if err != nil {
+		sendError(client, env, "Failed to get revisions")
+		log.Println("Failed to get revisions:", err)
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"postID":    req.PostID,
+		"revisions": revisions,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "postRevisions", ID: env.ID, Success: true, Message: "Post revisions", Data: responseData})
+}
+
+// SubmitCommentHandler handles comment submission over WebSocket
+func SubmitCommentHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	log.Println("Submit Comment Handler called.")
+
+	var req SubmitCommentRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permComment)
+	if !ok {
+		return
+	}
+	if !checkIdentity(client, env, session, req.Username) {
+		return
+	}
+	if !requireFields(client, env, "comment", req.Comment) {
+		return
+	}
+
+	// Deleted posts take no new comments, locked ones only from moderators
+	var locked bool
+	err := db.QueryRow("SELECT locked_at IS NOT NULL FROM posts WHERE post_ID = ? AND deleted_at IS NULL", req.PostID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "Post not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	if locked && !hasPermission(session.Role, permModerate) {
+		sendError(client, env, "This post is locked")
+		return
+	}
+
+	// A reply goes under a comment of the same post, as long as the thread is not nested too deeply
+	var parentID interface{}
+	if req.ParentID != 0 {
+		parent, err := GetCommentByID(db, req.ParentID)
+		if err == sql.ErrNoRows || (err == nil && (parent.PostID != req.PostID || parent.Deleted)) {
+			sendError(client, env, "Comment not found")
+			return
+		} else if err != nil {
+			sendError(client, env, "Database error")
+			log.Println("Database error:", err)
+			return
+		}
+		depth, err := getCommentDepth(db, req.ParentID)
+		if err != nil {
+			sendError(client, env, "Database error")
+			log.Println("Database error:", err)
+			return
+		}
+		if depth >= maxCommentDepth {
+			sendError(client, env, fmt.Sprintf("Replies cannot be nested more than %d levels deep", maxCommentDepth))
+			return
+		}
+		parentID = req.ParentID
+	}
+
+	result, err := db.Exec("INSERT INTO comments (post_ID, user_ID, content, created_at, parent_ID) VALUES (?, ?, ?, ?, ?)",
+		req.PostID, session.UserID, req.Comment, time.Now().UTC().Format(timestampFormat), parentID)
+	if err != nil {
+		sendError(client, env, "Failed to save comment")
+		log.Println("Database error:", err)
+		return
+	}
+	commentID, err := result.LastInsertId()
+	if err != nil {
+		sendError(client, env, "Failed to save comment")
+		log.Println("Database error:", err)
+		return
+	}
+	comment, err := GetCommentByID(db, int(commentID))
+	if err != nil {
+		sendError(client, env, "Failed to get comment")
+		log.Println("Failed to get comment:", err)
+		return
+	}
+
+	// Viewers of the post get the new comment with its parent, to place it in the tree
+	responseData := map[string]interface{}{
+		"postID":  req.PostID,
+		"comment": comment,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "newComment", ID: env.ID, Success: true, Message: "Comment saved", Data: responseData})
+	// The feed shows how many comments a post has, so the post's categories hear of it too
+	if err := notifyPostChange(db, client, req.PostID, "commentAdded", responseData); err != nil {
+		log.Println("Failed to notify post change:", err)
+	}
+}
+
+// maxCommentDepth is how many levels deep comments can be nested, counting
+// comments on the post itself as the first level
+const maxCommentDepth = 6
+
+// CommentsHandler sends the comments of a post as a tree, down to the
+// requested depth
+func CommentsHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req CommentsRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	if _, ok := requirePermission(client, db, env, permRead); !ok {
+		return
+	}
+	post, err := GetPostByID(db, req.PostID)
+	if err == sql.ErrNoRows || (err == nil && post.Deleted) {
+		sendError(client, env, "Post not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	depth := req.Depth
+	if depth <= 0 || depth > maxCommentDepth {
+		depth = maxCommentDepth
+	}
+
+	comments, err := GetCommentTree(db, req.PostID, depth)
+	if err != nil {
+		sendError(client, env, "Failed to get comments")
+		log.Println("Failed to get comments:", err)
+		return
+	}
+
+	// The post comes along, so its page can be opened without it being in the feed
+	responseData := map[string]interface{}{
+		"postID":   req.PostID,
+		"post":     post,
+		"depth":    depth,
+		"maxDepth": maxCommentDepth,
+		"comments": comments,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "comments", ID: env.ID, Success: true, Message: "Comments", Data: responseData})
+}
+
+// DeleteSessionHandler handles session deletion over WebSocket
+func DeleteSessionHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	log.Println("Delete Session Handler called.")
+
+	var req LogoutRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permSessions)
+	if !ok {
+		return
+	}
+	if !checkIdentity(client, env, session, req.Username) {
+		return
+	}
+
+	// Delete only this device's session, other devices stay logged in
+	_, err := db.Exec("DELETE FROM sessions WHERE session_ID = ?", session.ID)
+	if err != nil {
+		sendError(client, env, "Failed to delete session")
+		log.Println("Database error:", err)
+		return
+	}
+	unbindSession(client)
+
+	usersOnline, err := GetAllOnlineUsers(db)
+	if err != nil {
+		sendError(client, env, "Failed to get all online users")
+		return
+	}
+	// Send the data back to the frontend
+	responseData := struct {
+		AllUsersOnline []OnlineUser `json:"usersOnline"`
+	}{
+		AllUsersOnline: usersOnline,
+	}
+
+	// Send a success message back to the frontend
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "userLogout", ID: env.ID, Success: true, Message: "User logout successful"})
+	hub.Broadcast("updatAllUsersOnline", responseData)
+}
+
+// ListSessionsHandler sends the authenticated user's active sessions over WebSocket
+func ListSessionsHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	log.Println("List Sessions Handler called.")
+
+	session, ok := requirePermission(client, db, env, permSessions)
+	if !ok {
+		return
+	}
+	sendSessionList(client, db, env, session, "Sessions")
+}
+
+// RevokeSessionHandler ends one of the user's sessions, or all but the current
+// one when "others" is true, and logs out the connections using them
+func RevokeSessionHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	log.Println("Revoke Session Handler called.")
+
+	var req RevokeSessionRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permSessions)
+	if !ok {
+		return
+	}
+
+	var revoked []int
+	if req.Others {
+		ids, err := getOtherSessionIDs(db, session.UserID, session.ID)
+		if err != nil {
+			sendError(client, env, "Database error")
+			log.Println("Database error:", err)
+			return
+		}
+		revoked = ids
+	} else {
+		if req.SessionID == 0 {
+			sendError(client, env, "Invalid revokeSession payload: missing sessionID")
+			return
+		}
+		revoked = []int{req.SessionID}
+	}
+
+	for _, id := range revoked {
+		// The user_ID condition keeps users from revoking someone else's session
+		result, err := db.Exec("DELETE FROM sessions WHERE session_ID = ? AND user_ID = ?", id, session.UserID)
+		if err != nil {
+			sendError(client, env, "Failed to delete session")
+			log.Println("Database error:", err)
+			return
+		}
+		// Only connections of a session that was really deleted are closed
+		if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+			if !req.Others {
+				sendError(client, env, "Session not found")
+				return
+			}
+			continue
+		}
+		hub.revokeSession(id)
+	}
+
+	usersOnline, err := GetAllOnlineUsers(db)
+	if err != nil {
+		sendError(client, env, "Failed to get all online users")
+		return
+	}
+	responseData := struct {
+		AllUsersOnline []OnlineUser `json:"usersOnline"`
+	}{
+		AllUsersOnline: usersOnline,
+	}
+	hub.Broadcast("updatAllUsersOnline", responseData)
+
+	// Answer with the sessions that are left, even if the current one was among the revoked
+	sendSessionList(client, db, env, session, "Session revoked")
+}
+
+// getOtherSessionIDs returns the IDs of the user's sessions except currentID
+func getOtherSessionIDs(db *sql.DB, userID, currentID int) ([]int, error) {
+	rows, err := db.Query("SELECT session_ID FROM sessions WHERE user_ID = ? AND session_ID != ?", userID, currentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// sendSessionList sends the user's active sessions to the connection
+func sendSessionList(client *Client, db *sql.DB, env Envelope, session *Session, message string) {
+	sessions, err := GetSessionsForUser(db, session.UserID, session.ID)
+	if err != nil {
+		sendError(client, env, "Failed to get sessions")
+		log.Println("Database error:", err)
+		return
+	}
+	responseData := struct {
+		Sessions []SessionInfo `json:"sessions"`
+	}{
+		Sessions: sessions,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "sessionList", ID: env.ID, Success: true, Message: message, Data: responseData})
+}
+
+// topicExists reports whether what a topic is about can be followed: a post
+// that was not deleted or a category that is not archived
+func topicExists(db *sql.DB, kind string, id int) (bool, error) {
+	var err error
+	switch kind {
+	case "post":
+		_, err = getPostAuthor(id, db)
+	case "category":
+		var category Category
+		category, err = getCategory(db, id)
+		if err == nil && category.Archived {
+			return false, nil
+		}
+	}
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SubscribeHandler subscribes the connection to live updates on a topic
+func SubscribeHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req TopicRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	if _, ok := requirePermission(client, db, env, permRead); !ok {
+		return
+	}
+	kind, id, ok := parseTopic(req.Topic)
+	if !ok {
+		sendError(client, env, "Unknown topic")
+		return
+	}
+	exists, err := topicExists(db, kind, id)
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	if !exists {
+		sendError(client, env, "Unknown topic")
+		return
+	}
+
+	hub.Subscribe(client, req.Topic)
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "subscribed", ID: env.ID, Success: true, Message: "Subscribed", Data: req})
+}
+
+// UnsubscribeHandler stops live updates on a topic for the connection
+func UnsubscribeHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req TopicRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	if _, ok := requirePermission(client, db, env, permRead); !ok {
+		return
+	}
+
+	hub.Unsubscribe(client, req.Topic)
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "unsubscribed", ID: env.ID, Success: true, Message: "Unsubscribed", Data: req})
+}
+
+// SubmitMessageHandler handles message submission over WebSocket
+func SubmitMessageHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	log.Println("Submit Message Handler called.")
+
+	var req NewMessageRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permMessage)
+	if !ok {
+		return
+	}
+	if !checkIdentity(client, env, session, req.Sender) {
+		return
+	}
+	if !requireFields(client, env, "receiver", req.Receiver, "content", req.Content) {
+		return
+	}
+
+	receiverID, receiver, err := getUser(req.Receiver, db)
+	if err != nil {
+		sendError(client, env, "Unknown receiver")
+		return
+	}
+
+	// Timestamps are set by the server, in the same ISO format the column already holds
+	createdAt := time.Now().UTC().Format(timestampFormat)
+	result, err := db.Exec("INSERT INTO private_messages (sender, receiver, content, created_at) VALUES (?, ?, ?, ?)", session.Username, receiver, req.Content, createdAt)
+	if err != nil {
+		sendError(client, env, "Failed to insert message into the database")
+		log.Println("Database error:", err)
+		return
+	}
+	messageID, err := result.LastInsertId()
+	if err != nil {
+		sendError(client, env, "Failed to fetch message")
+		log.Println("Database error:", err)
+		return
+	}
+	message, err := GetMessageByID(db, messageID)
+	if err != nil {
+		sendError(client, env, "Failed to fetch message")
+		log.Println("Failed to fetch message:", err)
 		return
 	}
 
 	// Prepare data to be sent over WebSocket
 	responseData := map[string]interface{}{
-		"allMessages": allMessages,
+		"message": message,
 	}
 
-	// Send data over WebSocket
-	SendWebSocketMessageSuccess(conn, SuccessResponse{Type: "newMessageAdd", Success: true, Message: "Message sent successfully", Data: responseData})
-	BroadcastChanges(conn, "updateAllMessages", responseData)
+	// Only the two participants learn about the message; the sending
+	// connection has it from the response
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "newMessageAdd", ID: env.ID, Success: true, Message: "Message sent successfully", Data: responseData})
+	hub.NotifyExcept(client, nil, []int{session.UserID, receiverID}, "newPrivateMessage", responseData)
+}
+
+const (
+	// defaultConversationPage is how many messages getConversation returns
+	// when the request does not say
+	defaultConversationPage = 10
+
+	// maxConversationPage caps the limit a client may ask for
+	maxConversationPage = 50
+)
+
+// GetConversationHandler sends a page of the messages between the logged-in
+// user and a peer, see GetConversationPage
+func GetConversationHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req ConversationRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permMessage)
+	if !ok {
+		return
+	}
+	if !requireFields(client, env, "peer", req.Peer) {
+		return
+	}
+
+	peer, err := getStoredUsername(req.Peer, db)
+	if err != nil {
+		sendError(client, env, "Unknown peer")
+		return
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultConversationPage
+	} else if limit > maxConversationPage {
+		limit = maxConversationPage
+	}
+	if req.Before < 0 {
+		sendError(client, env, "Invalid getConversation payload: before must be a message ID")
+		return
+	}
+
+	// Ask for one message more than the page holds to learn whether there are older ones
+	messages, err := GetConversationPage(db, session.Username, peer, req.Before, limit+1)
+	if err == errUnknownMessage {
+		sendError(client, env, "Invalid getConversation payload: before is not a message of the conversation")
+		return
+	} else if err != nil {
+		sendError(client, env, "Failed to fetch messages")
+		log.Println("Failed to fetch messages:", err)
+		return
+	}
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[1:]
+	}
+
+	responseData := map[string]interface{}{
+		"peer":     peer,
+		"before":   req.Before,
+		"messages": messages,
+		"hasMore":  hasMore,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "conversation", ID: env.ID, Success: true, Message: "Conversation", Data: responseData})
 }
@@ -0,0 +1,270 @@
+package forum
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Hub keeps track of the connected clients, the user each of them is logged
+// in as and the topics they subscribed to, so updates can be delivered to
+// exactly the clients that should see them.
+type Hub struct {
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+	users   map[int]map[*Client]struct{}
+	topics  map[string]map[*Client]struct{}
+}
+
+// hub is the hub every WebSocket connection registers with
+var hub = newHub()
+
+func newHub() *Hub {
+	return &Hub{
+		clients: make(map[*Client]struct{}),
+		users:   make(map[int]map[*Client]struct{}),
+		topics:  make(map[string]map[*Client]struct{}),
+	}
+}
+
+type WebSocketUpdate struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// encodeUpdate encodes an update once so it can be queued for many clients
+func encodeUpdate(messagetype string, data interface{}) ([]byte, bool) {
+	message, err := json.Marshal(WebSocketUpdate{Type: messagetype, Data: data})
+	if err != nil {
+		log.Println("Error encoding update:", err)
+		return nil, false
+	}
+	return message, true
+}
+
+// register adds a newly connected client
+func (h *Hub) register(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+}
+
+// unregister removes a client together with its login and subscriptions
+func (h *Hub) unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.setSessionLocked(c, nil)
+	for topic := range c.topics {
+		h.unsubscribeLocked(c, topic)
+	}
+	delete(h.clients, c)
+}
+
+// session returns the session the client is logged in as, or nil
+func (h *Hub) session(c *Client) *Session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return c.session
+}
+
+// setSession logs the client in as the session's user, or out when nil
+func (h *Hub) setSession(c *Client, session *Session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.setSessionLocked(c, session)
+}
+
+func (h *Hub) setSessionLocked(c *Client, session *Session) {
+	if old := c.session; old != nil {
+		delete(h.users[old.UserID], c)
+		if len(h.users[old.UserID]) == 0 {
+			delete(h.users, old.UserID)
+		}
+	}
+	c.session = session
+	if session != nil {
+		if h.users[session.UserID] == nil {
+			h.users[session.UserID] = make(map[*Client]struct{})
+		}
+		h.users[session.UserID][c] = struct{}{}
+	}
+}
+
+// revokeSession logs out every client using the given session and tells
+// them their session was ended elsewhere
+func (h *Hub) revokeSession(sessionID int) {
+	message, ok := encodeUpdate("sessionRevoked", nil)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if c.session == nil || c.session.ID != sessionID {
+			continue
+		}
+		h.setSessionLocked(c, nil)
+		c.sendRaw(message)
+	}
+}
+
+// Broadcast sends an update to every logged-in client
+func (h *Hub) Broadcast(messagetype string, data interface{}) {
+	h.BroadcastExcept(nil, messagetype, data)
+}
+
+// BroadcastExcept sends an update to every logged-in client but one, usually
+// the client whose request caused it and that got the response instead
+func (h *Hub) BroadcastExcept(except *Client, messagetype string, data interface{}) {
+	message, ok := encodeUpdate(messagetype, data)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if c.session != nil && c != except {
+			c.sendRaw(message)
+		}
+	}
+}
+
+// SendToUser sends an update to every client the user is logged in on
+func (h *Hub) SendToUser(userID int, messagetype string, data interface{}) {
+	h.SendToUsers([]int{userID}, messagetype, data)
+}
+
+// SendToUserExcept sends an update to every client of the user but one
+func (h *Hub) SendToUserExcept(userID int, except *Client, messagetype string, data interface{}) {
+	h.NotifyExcept(except, nil, []int{userID}, messagetype, data)
+}
+
+// SendToUsers sends an update to every client of the given users. A user
+// listed twice still gets the update once.
+func (h *Hub) SendToUsers(userIDs []int, messagetype string, data interface{}) {
+	message, ok := encodeUpdate(messagetype, data)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := make(map[int]bool)
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		for c := range h.users[userID] {
+			c.sendRaw(message)
+		}
+	}
+}
+
+// Publish sends an update to the logged-in clients subscribed to a topic
+func (h *Hub) Publish(topic, messagetype string, data interface{}) {
+	message, ok := encodeUpdate(messagetype, data)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.topics[topic] {
+		if c.session != nil {
+			c.sendRaw(message)
+		}
+	}
+}
+
+// Notify sends an update to the logged-in clients subscribed to one of the
+// topics and to every client of the given users. Each client gets it once.
+func (h *Hub) Notify(topics []string, userIDs []int, messagetype string, data interface{}) {
+	h.NotifyExcept(nil, topics, userIDs, messagetype, data)
+}
+
+// NotifyExcept is Notify leaving out one client
+func (h *Hub) NotifyExcept(except *Client, topics []string, userIDs []int, messagetype string, data interface{}) {
+	message, ok := encodeUpdate(messagetype, data)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sent := map[*Client]bool{except: true}
+	for _, topic := range topics {
+		for c := range h.topics[topic] {
+			if c.session != nil && !sent[c] {
+				sent[c] = true
+				c.sendRaw(message)
+			}
+		}
+	}
+	for _, userID := range userIDs {
+		for c := range h.users[userID] {
+			if !sent[c] {
+				sent[c] = true
+				c.sendRaw(message)
+			}
+		}
+	}
+}
+
+// Subscribe adds the client to a topic
+func (h *Hub) Subscribe(c *Client, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*Client]struct{})
+	}
+	h.topics[topic][c] = struct{}{}
+	c.topics[topic] = struct{}{}
+}
+
+// Unsubscribe removes the client from a topic
+func (h *Hub) Unsubscribe(c *Client, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unsubscribeLocked(c, topic)
+}
+
+func (h *Hub) unsubscribeLocked(c *Client, topic string) {
+	delete(c.topics, topic)
+	delete(h.topics[topic], c)
+	if len(h.topics[topic]) == 0 {
+		delete(h.topics, topic)
+	}
+}
+
+// postTopic is the topic carrying live updates about a single post
+func postTopic(postID int) string {
+	return "post:" + strconv.Itoa(postID)
+}
+
+// categoryTopic is the topic carrying new posts in a category
+func categoryTopic(categoryID int) string {
+	return "category:" + strconv.Itoa(categoryID)
+}
+
+// parseTopic splits a topic clients may subscribe to into its kind, "post"
+// or "category", and the ID of the post or category
+func parseTopic(topic string) (string, int, bool) {
+	kind, id, found := strings.Cut(topic, ":")
+	if !found {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return "", 0, false
+	}
+	switch kind {
+	case "post", "category":
+		return kind, n, true
+	}
+	return "", 0, false
+}
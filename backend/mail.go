@@ -0,0 +1,90 @@
+package forum
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mail is an email the forum sends to one of its users
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// MailSender delivers the forum's emails
+type MailSender interface {
+	Send(mail Mail) error
+}
+
+// Mailer is the sender the forum uses. It writes to the log until main
+// configures something else.
+var Mailer MailSender = FileMailSender{}
+
+// SMTPMailSender sends mail through an SMTP server. Username and Password are
+// only used when Username is set; the server must then support STARTTLS.
+type SMTPMailSender struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s SMTPMailSender) Send(mail Mail) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, []string{mail.To}, formatMail(s.From, mail))
+}
+
+// FileMailSender is for local development: it appends every mail to the file
+// at Path. Without a Path only the recipient and subject are logged.
+type FileMailSender struct {
+	Path string
+}
+
+func (f FileMailSender) Send(mail Mail) error {
+	if f.Path == "" {
+		// The body is left out, it can carry a live reset link
+		log.Printf("Mail not sent, no SMTP server or mail file configured: to %q, subject %q\n", mail.To, mail.Subject)
+		return nil
+	}
+
+	message := formatMail("forum@localhost", mail)
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(message, "\r\n"...)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// formatMail builds the message with the headers mail clients expect. Header
+// values cannot contain line breaks, so they cannot add headers of their own.
+func formatMail(from string, mail Mail) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", clean.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(mail.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", clean.Replace(mail.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,464 @@
+package forum
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Statuses of a report. Open reports make up the moderation queue.
+const (
+	reportOpen      = "open"
+	reportResolved  = "resolved"
+	reportDismissed = "dismissed"
+)
+
+const (
+	// maxReportReason caps the length of a report's reason, in characters
+	maxReportReason = 500
+
+	// defaultModerationPage is how many reports or log entries are listed
+	// when the request does not say
+	defaultModerationPage = 20
+
+	// maxModerationPage caps the limit a client may ask for
+	maxModerationPage = 50
+)
+
+// Report is a user's complaint about a post, comment or private message.
+// Author and Content describe the reported item as it is stored, also when
+// it has since been deleted.
+type Report struct {
+	ReportID  int    `json:"report_id"`
+	Kind      string `json:"kind"`
+	TargetID  int    `json:"target_id"`
+	PostID    int    `json:"post_id,omitempty"`
+	Author    string `json:"author"`
+	Content   string `json:"content"`
+	Reporter  string `json:"reporter"`
+	Reason    string `json:"reason"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+	ClosedBy  string `json:"closed_by,omitempty"`
+	ClosedAt  string `json:"closed_at,omitempty"`
+	Note      string `json:"note,omitempty"`
+}
+
+// ModerationEntry is one decision in the moderation audit trail. Kind and
+// TargetID name what the decision was about: a post, comment, message or user.
+type ModerationEntry struct {
+	LogID     int    `json:"log_id"`
+	Moderator string `json:"moderator"`
+	Action    string `json:"action"`
+	Kind      string `json:"kind"`
+	TargetID  int    `json:"target_id"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// logModeration adds a moderator's decision to the audit trail
+func logModeration(db *sql.DB, moderatorID int, action, kind string, targetID int, note string) error {
+	_, err := db.Exec(
+		"INSERT INTO moderation_log (moderator_ID, action, kind, target_ID, note, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		moderatorID, action, kind, targetID, note, time.Now().UTC().Format(timestampFormat),
+	)
+	return err
+}
+
+// getModeratorIDs lists the users whose role allows moderating
+func getModeratorIDs(db *sql.DB) ([]int, error) {
+	var roles []interface{}
+	for role := range rolePermissions {
+		if hasPermission(role, permModerate) {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(roles)), ", ")
+	rows, err := db.Query("SELECT user_ID FROM users WHERE role IN ("+placeholders+")", roles...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// reportSelect selects reports with their reporter, closing moderator and
+// the item they are about
+const reportSelect = `
+	SELECT r.report_ID, r.kind, r.target_ID, reporter.username, r.reason, r.status, r.created_at,
+	       COALESCE(closer.username, ''), COALESCE(r.closed_at, ''), r.note,
+	       CASE r.kind
+	           WHEN 'post' THEN r.target_ID
+	           WHEN 'comment' THEN (SELECT post_ID FROM comments WHERE comment_ID = r.target_ID)
+	           ELSE 0
+	       END,
+	       COALESCE(CASE r.kind
+	           WHEN 'post' THEN (SELECT u.username FROM posts AS p INNER JOIN users AS u ON p.user_ID = u.user_ID WHERE p.post_ID = r.target_ID)
+	           WHEN 'comment' THEN (SELECT u.username FROM comments AS c INNER JOIN users AS u ON c.user_ID = u.user_ID WHERE c.comment_ID = r.target_ID)
+	           WHEN 'message' THEN (SELECT sender FROM private_messages WHERE message_ID = r.target_ID)
+	       END, ''),
+	       COALESCE(CASE r.kind
+	           WHEN 'post' THEN (SELECT title || ': ' || content FROM posts WHERE post_ID = r.target_ID)
+	           WHEN 'comment' THEN (SELECT content FROM comments WHERE comment_ID = r.target_ID)
+	           WHEN 'message' THEN (SELECT content FROM private_messages WHERE message_ID = r.target_ID)
+	       END, '')
+	FROM reports AS r
+	INNER JOIN users AS reporter ON r.reporter_ID = reporter.user_ID
+	LEFT JOIN users AS closer ON r.closed_by = closer.user_ID
+`
+
+func queryReports(db *sql.DB, where string, args ...interface{}) ([]Report, error) {
+	rows, err := db.Query(reportSelect+"WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := make([]Report, 0)
+	for rows.Next() {
+		var report Report
+		err := rows.Scan(
+			&report.ReportID, &report.Kind, &report.TargetID, &report.Reporter, &report.Reason, &report.Status, &report.CreatedAt,
+			&report.ClosedBy, &report.ClosedAt, &report.Note,
+			&report.PostID, &report.Author, &report.Content,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// GetReports lists the reports with a status, newest first, starting before
+// the report with ID before unless it is 0
+func GetReports(db *sql.DB, status string, before, limit int) ([]Report, error) {
+	return queryReports(db, "r.status = ? AND (? = 0 OR r.report_ID < ?) ORDER BY r.report_ID DESC LIMIT ?", status, before, before, limit)
+}
+
+// GetModerationLog lists the moderation audit trail, newest first, starting
+// before the entry with ID before unless it is 0
+func GetModerationLog(db *sql.DB, before, limit int) ([]ModerationEntry, error) {
+	query := `
+		SELECT l.log_ID, u.username, l.action, l.kind, l.target_ID, l.note, l.created_at
+		FROM moderation_log AS l
+		INNER JOIN users AS u ON l.moderator_ID = u.user_ID
+		WHERE ? = 0 OR l.log_ID < ?
+		ORDER BY l.log_ID DESC
+		LIMIT ?
+	`
+	rows, err := db.Query(query, before, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]ModerationEntry, 0)
+	for rows.Next() {
+		var entry ModerationEntry
+		err := rows.Scan(&entry.LogID, &entry.Moderator, &entry.Action, &entry.Kind, &entry.TargetID, &entry.Note, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// moderationPageLimit turns the limit a client asked for into one the server serves
+func moderationPageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultModerationPage
+	} else if limit > maxModerationPage {
+		return maxModerationPage
+	}
+	return limit
+}
+
+// reportTarget checks that the session's user can see the item a report
+// request is about and returns its kind and ID. On failure an error is sent
+// and ok is false.
+func reportTarget(client *Client, db *sql.DB, env Envelope, session *Session, req ReportRequest) (kind string, id int, ok bool) {
+	given := 0
+	for _, id := range []int{req.PostID, req.CommentID, req.MessageID} {
+		if id != 0 {
+			given++
+		}
+	}
+	if given != 1 {
+		sendError(client, env, "Invalid report payload: give one of postID, commentID or messageID")
+		return "", 0, false
+	}
+
+	var err error
+	var notFound string
+	switch {
+	case req.PostID != 0:
+		kind, id, notFound = "post", req.PostID, "Post not found"
+		_, err = getPostAuthor(req.PostID, db)
+	case req.CommentID != 0:
+		kind, id, notFound = "comment", req.CommentID, "Comment not found"
+		_, err = getCommentPost(req.CommentID, db)
+	default:
+		// Only the sender and the receiver can see a message, so only they can report it
+		kind, id, notFound = "message", req.MessageID, "Message not found"
+		var message Message
+		message, err = GetMessageByID(db, int64(req.MessageID))
+		if err == nil && message.Sender != session.Username && message.Receiver != session.Username {
+			err = sql.ErrNoRows
+		}
+	}
+	if err == sql.ErrNoRows {
+		sendError(client, env, notFound)
+		return "", 0, false
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return "", 0, false
+	}
+	return kind, id, true
+}
+
+// ReportHandler files a report about a post, comment or private message and
+// puts it in the moderators' queue
+func ReportHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req ReportRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permReport)
+	if !ok {
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if !requireFields(client, env, "reason", req.Reason) {
+		return
+	}
+	if utf8.RuneCountInString(req.Reason) > maxReportReason {
+		sendError(client, env, "The reason is too long")
+		return
+	}
+	kind, targetID, ok := reportTarget(client, db, env, session, req)
+	if !ok {
+		return
+	}
+
+	// A user's report stays in the queue until a moderator decides on it
+	var open int
+	err := db.QueryRow("SELECT COUNT(*) FROM reports WHERE reporter_ID = ? AND kind = ? AND target_ID = ? AND status = ?",
+		session.UserID, kind, targetID, reportOpen).Scan(&open)
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	if open > 0 {
+		sendError(client, env, "You already reported this "+kind)
+		return
+	}
+
+	result, err := db.Exec("INSERT INTO reports (reporter_ID, kind, target_ID, reason, status, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		session.UserID, kind, targetID, req.Reason, reportOpen, time.Now().UTC().Format(timestampFormat))
+	if err != nil {
+		sendError(client, env, "Failed to save report")
+		log.Println("Database error:", err)
+		return
+	}
+	reportID, err := result.LastInsertId()
+	if err != nil {
+		sendError(client, env, "Failed to save report")
+		log.Println("Database error:", err)
+		return
+	}
+
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "reportFiled", ID: env.ID, Success: true, Message: "Report filed", Data: map[string]interface{}{
+		"reportID": reportID,
+	}})
+
+	reports, err := queryReports(db, "r.report_ID = ?", reportID)
+	if err != nil || len(reports) == 0 {
+		log.Println("Failed to fetch report:", err)
+		return
+	}
+	moderatorIDs, err := getModeratorIDs(db)
+	if err != nil {
+		log.Println("Failed to fetch moderators:", err)
+		return
+	}
+	hub.SendToUsers(moderatorIDs, "newReport", map[string]interface{}{
+		"report": reports[0],
+	})
+}
+
+// ListReportsHandler sends a page of the reports with a status, by default
+// the open ones making up the moderation queue
+func ListReportsHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req ReportsRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	if _, ok := requirePermission(client, db, env, permModerate); !ok {
+		return
+	}
+
+	status := req.Status
+	if status == "" {
+		status = reportOpen
+	} else if status != reportOpen && status != reportResolved && status != reportDismissed {
+		sendError(client, env, "Unknown report status: "+status)
+		return
+	}
+
+	reports, err := GetReports(db, status, req.Before, moderationPageLimit(req.Limit))
+	if err != nil {
+		sendError(client, env, "Failed to fetch reports")
+		log.Println("Database error:", err)
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"status":  status,
+		"before":  req.Before,
+		"reports": reports,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "reports", ID: env.ID, Success: true, Message: "Reports", Data: responseData})
+}
+
+// CloseReportHandler resolves or dismisses a report, depending on whether the
+// request is resolveReport or dismissReport. The decision applies to every
+// open report about the same item and is written to the audit trail.
+func CloseReportHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req CloseReportRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permModerate)
+	if !ok {
+		return
+	}
+
+	status := reportResolved
+	if env.Type == "dismissReport" {
+		status = reportDismissed
+	}
+
+	var kind string
+	var targetID int
+	err := db.QueryRow("SELECT kind, target_ID FROM reports WHERE report_ID = ? AND status = ?", req.ReportID, reportOpen).Scan(&kind, &targetID)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "Report not found or already closed")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	reportIDs, err := closeReports(db, kind, targetID, status, session.UserID, strings.TrimSpace(req.Note))
+	if err != nil {
+		sendError(client, env, "Failed to close report")
+		log.Println("Database error:", err)
+		return
+	}
+	if err := logModeration(db, session.UserID, env.Type, kind, targetID, strings.TrimSpace(req.Note)); err != nil {
+		log.Println("Failed to write moderation log:", err)
+	}
+
+	responseData := map[string]interface{}{
+		"reportIDs": reportIDs,
+		"status":    status,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "reportClosed", ID: env.ID, Success: true, Message: "Report closed", Data: responseData})
+
+	moderatorIDs, err := getModeratorIDs(db)
+	if err != nil {
+		log.Println("Failed to fetch moderators:", err)
+		return
+	}
+	hub.SendToUsers(moderatorIDs, "reportClosed", responseData)
+}
+
+// closeReports gives every open report about an item the status and returns their IDs
+func closeReports(db *sql.DB, kind string, targetID int, status string, moderatorID int, note string) ([]int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT report_ID FROM reports WHERE kind = ? AND target_ID = ? AND status = ?", kind, targetID, reportOpen)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("UPDATE reports SET status = ?, closed_by = ?, closed_at = ?, note = ? WHERE kind = ? AND target_ID = ? AND status = ?",
+		status, moderatorID, time.Now().UTC().Format(timestampFormat), note, kind, targetID, reportOpen)
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, tx.Commit()
+}
+
+// ModerationLogHandler sends a page of the moderation audit trail
+func ModerationLogHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req ModerationLogRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	if _, ok := requirePermission(client, db, env, permModerate); !ok {
+		return
+	}
+
+	entries, err := GetModerationLog(db, req.Before, moderationPageLimit(req.Limit))
+	if err != nil {
+		sendError(client, env, "Failed to fetch moderation log")
+		log.Println("Database error:", err)
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"before":  req.Before,
+		"entries": entries,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "moderationLog", ID: env.ID, Success: true, Message: "Moderation log", Data: responseData})
+}
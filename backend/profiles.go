@@ -0,0 +1,185 @@
+package forum
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// recentActivityLimit is how many recent posts and comments a profile lists
+	recentActivityLimit = 10
+	// activityExcerpt bounds the content shown for each activity, in characters
+	activityExcerpt = 100
+)
+
+// PrivacySettings say which registration fields a user's public profile shows
+type PrivacySettings struct {
+	ShowName   bool `json:"showName"`
+	ShowEmail  bool `json:"showEmail"`
+	ShowAge    bool `json:"showAge"`
+	ShowGender bool `json:"showGender"`
+}
+
+// Activity is a post or comment listed on its author's profile
+type Activity struct {
+	Kind      string `json:"kind"`
+	PostID    int    `json:"post_id"`
+	CommentID int    `json:"comment_id,omitempty"`
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Profile is what other users can see about a user. The registration fields
+// are only filled in when the privacy settings allow, or for the user
+// themselves, who also gets their Privacy settings.
+type Profile struct {
+	Username       string           `json:"username"`
+	Role           string           `json:"role"`
+	JoinedAt       string           `json:"joined_at"`
+	FirstName      string           `json:"first_name,omitempty"`
+	LastName       string           `json:"last_name,omitempty"`
+	Email          string           `json:"email,omitempty"`
+	Age            int              `json:"age,omitempty"`
+	Gender         string           `json:"gender,omitempty"`
+	PostCount      int              `json:"post_count"`
+	CommentCount   int              `json:"comment_count"`
+	RecentActivity []Activity       `json:"recent_activity"`
+	Privacy        *PrivacySettings `json:"privacy,omitempty"`
+}
+
+// GetProfile returns the profile of userID as the viewer viewerID sees it
+func GetProfile(db *sql.DB, userID, viewerID int) (Profile, error) {
+	var profile Profile
+	var firstName, lastName, email, gender string
+	var age int
+	var privacy PrivacySettings
+	err := db.QueryRow(`
+		SELECT username, role, CAST(created_at AS TEXT), first_name, last_name, email, age, gender,
+		       show_name, show_email, show_age, show_gender,
+		       (SELECT COUNT(*) FROM posts WHERE user_ID = ?1 AND deleted_at IS NULL),
+		       (SELECT COUNT(*) FROM comments WHERE user_ID = ?1 AND deleted_at IS NULL)
+		FROM users
+		WHERE user_ID = ?1
+	`, userID).Scan(&profile.Username, &profile.Role, &profile.JoinedAt, &firstName, &lastName, &email, &age, &gender,
+		&privacy.ShowName, &privacy.ShowEmail, &privacy.ShowAge, &privacy.ShowGender,
+		&profile.PostCount, &profile.CommentCount)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	own := userID == viewerID
+	if own {
+		profile.Privacy = &privacy
+	}
+	if own || privacy.ShowName {
+		profile.FirstName, profile.LastName = firstName, lastName
+	}
+	if own || privacy.ShowEmail {
+		profile.Email = email
+	}
+	if own || privacy.ShowAge {
+		profile.Age = age
+	}
+	if own || privacy.ShowGender {
+		profile.Gender = gender
+	}
+
+	profile.RecentActivity, err = getRecentActivity(db, userID, recentActivityLimit)
+	return profile, err
+}
+
+// getRecentActivity lists a user's latest posts and comments that have not
+// been deleted, newest first
+func getRecentActivity(db *sql.DB, userID, limit int) ([]Activity, error) {
+	rows, err := db.Query(`
+		SELECT 'post', p.post_ID, 0, p.title, p.content, CAST(p.created_at AS TEXT) AS at
+		FROM posts AS p
+		WHERE p.user_ID = ?1 AND p.deleted_at IS NULL
+		UNION ALL
+		SELECT 'comment', p.post_ID, com.comment_ID, p.title, com.content, CAST(com.created_at AS TEXT) AS at
+		FROM comments AS com
+		INNER JOIN posts AS p ON com.post_ID = p.post_ID
+		WHERE com.user_ID = ?1 AND com.deleted_at IS NULL AND p.deleted_at IS NULL
+		ORDER BY at DESC
+		LIMIT ?2
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activity := make([]Activity, 0)
+	for rows.Next() {
+		var a Activity
+		if err := rows.Scan(&a.Kind, &a.PostID, &a.CommentID, &a.Title, &a.Content, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if content := []rune(a.Content); len(content) > activityExcerpt {
+			a.Content = string(content[:activityExcerpt]) + "…"
+		}
+		activity = append(activity, a)
+	}
+	return activity, rows.Err()
+}
+
+// ProfileHandler sends the public profile of a user
+func ProfileHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req UserRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permRead)
+	if !ok {
+		return
+	}
+	if !requireFields(client, env, "username", req.Username) {
+		return
+	}
+
+	userID, _, err := getUser(strings.TrimSpace(req.Username), db)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "User not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	profile, err := GetProfile(db, userID, session.UserID)
+	if err != nil {
+		sendError(client, env, "Failed to get profile")
+		log.Println("Failed to get profile:", err)
+		return
+	}
+
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "profile", ID: env.ID, Success: true, Message: "Profile", Data: map[string]interface{}{
+		"profile": profile,
+	}})
+}
+
+// UpdatePrivacyHandler saves which registration fields the user's profile shows
+func UpdatePrivacyHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req PrivacySettings
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permProfile)
+	if !ok {
+		return
+	}
+
+	_, err := db.Exec("UPDATE users SET show_name = ?, show_email = ?, show_age = ?, show_gender = ? WHERE user_ID = ?",
+		req.ShowName, req.ShowEmail, req.ShowAge, req.ShowGender, session.UserID)
+	if err != nil {
+		sendError(client, env, "Failed to save privacy settings")
+		log.Println("Database error:", err)
+		return
+	}
+
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "privacyUpdated", ID: env.ID, Success: true, Message: "Privacy settings saved", Data: map[string]interface{}{
+		"privacy": req,
+	}})
+}
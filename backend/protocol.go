@@ -0,0 +1,272 @@
+package forum
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ProtocolVersion is the version of the WebSocket protocol the server speaks.
+// The protocol is documented in docs/PROTOCOL.md.
+const ProtocolVersion = 1
+
+// Envelope wraps every frame sent by a client. Payload holds the request
+// struct matching Type; ID is chosen by the client and echoed in the
+// response, whether it succeeds or fails.
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type RegisterRequest struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first-name"`
+	LastName  string `json:"last-name"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Age       string `json:"age"`
+	Gender    string `json:"gender"`
+}
+
+type LoginRequest struct {
+	Identifier string `json:"identifier"`
+	Password   string `json:"password"`
+}
+
+type CreatePostRequest struct {
+	CreatedBy  string   `json:"createdBy,omitempty"`
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	Categories []string `json:"categories"`
+}
+
+type FeedRequest struct {
+	Limit      int         `json:"limit,omitempty"`
+	Cursor     *FeedCursor `json:"cursor,omitempty"`
+	Category   string      `json:"category,omitempty"`
+	Sort       string      `json:"sort,omitempty"`
+	Subscribed bool        `json:"subscribed,omitempty"`
+}
+
+type SearchRequest struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+type EditPostRequest struct {
+	PostID     int      `json:"postID"`
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	Categories []string `json:"categories"`
+}
+
+// PostRequest names the post a request is about
+type PostRequest struct {
+	PostID int `json:"postID"`
+}
+
+// LockPostRequest locks a post against new comments, or unlocks it
+type LockPostRequest struct {
+	PostID int  `json:"postID"`
+	Locked bool `json:"locked"`
+}
+
+// SetRoleRequest gives a user one of the roles
+type SetRoleRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// UpdateProfileRequest replaces the registration fields a user can change.
+// CurrentPassword is only needed to change the email.
+type UpdateProfileRequest struct {
+	Email           string `json:"email"`
+	FirstName       string `json:"first-name"`
+	LastName        string `json:"last-name"`
+	Gender          string `json:"gender"`
+	CurrentPassword string `json:"currentPassword"`
+}
+
+// ChangePasswordRequest replaces the user's password after checking the old one
+type ChangePasswordRequest struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+// DeleteAccountRequest deletes the user's account, confirmed with the password
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// CategoryRequest creates a category, or renames and describes the one with
+// CategoryID
+type CategoryRequest struct {
+	CategoryID  int    `json:"categoryID,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// CategorySubscriptionRequest names the category a user subscribes to or
+// unsubscribes from
+type CategorySubscriptionRequest struct {
+	CategoryID int `json:"categoryID"`
+}
+
+// ReorderCategoriesRequest lists every category ID in the new order
+type ReorderCategoriesRequest struct {
+	CategoryIDs []int `json:"categoryIDs"`
+}
+
+// ArchiveCategoryRequest archives a category, or brings it back
+type ArchiveCategoryRequest struct {
+	CategoryID int  `json:"categoryID"`
+	Archived   bool `json:"archived"`
+}
+
+// UserRequest names the user a request is about
+type UserRequest struct {
+	Username string `json:"username"`
+}
+
+// BanRequest bans a user, or suspends them until Until when it is set
+type BanRequest struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+	Until    string `json:"until,omitempty"`
+}
+
+// CommentRequest names the comment a request is about
+type CommentRequest struct {
+	CommentID int `json:"commentID"`
+}
+
+type SubmitCommentRequest struct {
+	Username string `json:"username,omitempty"`
+	PostID   int    `json:"postID"`
+	ParentID int    `json:"parentID,omitempty"`
+	Comment  string `json:"comment"`
+}
+
+type CommentsRequest struct {
+	PostID int `json:"postID"`
+	Depth  int `json:"depth,omitempty"`
+}
+
+// ReactRequest likes or dislikes a post or a comment; exactly one of PostID
+// and CommentID is set
+type ReactRequest struct {
+	PostID    int    `json:"postID,omitempty"`
+	CommentID int    `json:"commentID,omitempty"`
+	Reaction  string `json:"reaction"`
+}
+
+type LogoutRequest struct {
+	Username string `json:"username,omitempty"`
+}
+
+type NewMessageRequest struct {
+	Sender   string `json:"sender,omitempty"`
+	Receiver string `json:"receiver"`
+	Content  string `json:"content"`
+}
+
+type ConversationRequest struct {
+	Peer   string `json:"peer"`
+	Before int    `json:"before,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// MessageReactionRequest attaches an emoji to a private message, or takes it
+// back when the user already attached it
+type MessageReactionRequest struct {
+	MessageID int    `json:"messageID"`
+	Emoji     string `json:"emoji"`
+}
+
+// ReportRequest reports a post, comment or private message to the
+// moderators; exactly one of the IDs is set
+type ReportRequest struct {
+	PostID    int    `json:"postID,omitempty"`
+	CommentID int    `json:"commentID,omitempty"`
+	MessageID int    `json:"messageID,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+// ReportsRequest asks for a page of the reports with a status
+type ReportsRequest struct {
+	Status string `json:"status,omitempty"`
+	Before int    `json:"before,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// CloseReportRequest resolves or dismisses a report
+type CloseReportRequest struct {
+	ReportID int    `json:"reportID"`
+	Note     string `json:"note,omitempty"`
+}
+
+// ModerationLogRequest asks for a page of the moderation audit trail
+type ModerationLogRequest struct {
+	Before int `json:"before,omitempty"`
+	Limit  int `json:"limit,omitempty"`
+}
+
+type RevokeSessionRequest struct {
+	SessionID int  `json:"sessionID"`
+	Others    bool `json:"others"`
+}
+
+type TopicRequest struct {
+	Topic string `json:"topic"`
+}
+
+// parseEnvelope decodes a frame and checks that its version is supported
+func parseEnvelope(p []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(p, &env); err != nil {
+		return Envelope{}, fmt.Errorf("Invalid message format: %v", err)
+	}
+	if env.Type == "" {
+		return env, fmt.Errorf("Invalid message format: missing type")
+	}
+	if env.ID == "" {
+		return env, fmt.Errorf("Invalid message format: missing id")
+	}
+	if env.Version < 1 || env.Version > ProtocolVersion {
+		return env, fmt.Errorf("Unsupported protocol version %d, server speaks %d", env.Version, ProtocolVersion)
+	}
+	return env, nil
+}
+
+// decodePayload unmarshals the envelope payload into req. On failure an
+// error is sent to the client and false is returned.
+func decodePayload(client *Client, env Envelope, req interface{}) bool {
+	payload := env.Payload
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+	if err := json.Unmarshal(payload, req); err != nil {
+		sendError(client, env, fmt.Sprintf("Invalid %s payload: %v", env.Type, err))
+		return false
+	}
+	return true
+}
+
+// requireFields sends an error naming the first empty field, if any.
+// Fields are given as name/value pairs.
+func requireFields(client *Client, env Envelope, fields ...string) bool {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if strings.TrimSpace(fields[i+1]) == "" {
+			sendError(client, env, fmt.Sprintf("Invalid %s payload: missing %s", env.Type, fields[i]))
+			return false
+		}
+	}
+	return true
+}
+
+// sendError sends the error response for a request, echoing its ID
+func sendError(client *Client, env Envelope, message string) {
+	SendWebSocketMessage(client, Response{Type: "Error", ID: env.ID, Success: false, Message: message})
+}
@@ -0,0 +1,244 @@
+package forum
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"unicode"
+	"unicode/utf8"
+)
+
+// reactionValues maps the reactions a client can send to the value stored
+// in the reactions table
+var reactionValues = map[string]int{
+	"like":    1,
+	"dislike": -1,
+}
+
+// reactionCounts selects the like and dislike counts of the item whose ID is
+// in idColumn. target is the reactions column naming the item, post_ID or
+// comment_ID.
+func reactionCounts(target, idColumn string) string {
+	return `(SELECT COUNT(*) FROM reactions AS r WHERE r.` + target + ` = ` + idColumn + ` AND r.value = 1),
+	        (SELECT COUNT(*) FROM reactions AS r WHERE r.` + target + ` = ` + idColumn + ` AND r.value = -1)`
+}
+
+// getReactionCounts counts the likes and dislikes of one post or comment
+func getReactionCounts(db *sql.DB, target string, id int) (likes, dislikes int, err error) {
+	err = db.QueryRow("SELECT "+reactionCounts(target, "?1"), id).Scan(&likes, &dislikes)
+	return likes, dislikes, err
+}
+
+// toggleReaction records userID's reaction to a post or comment. Sending the
+// reaction the user already gave takes it back. The user's reaction after
+// the toggle is returned, 0 when there is none.
+func toggleReaction(db *sql.DB, userID int, target string, id, value int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var current int
+	err = tx.QueryRow("SELECT value FROM reactions WHERE "+target+" = ? AND user_ID = ?", id, userID).Scan(&current)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec("INSERT INTO reactions (user_ID, "+target+", value) VALUES (?, ?, ?)", userID, id, value)
+	case err != nil:
+		return 0, err
+	case current == value:
+		value = 0
+		_, err = tx.Exec("DELETE FROM reactions WHERE "+target+" = ? AND user_ID = ?", id, userID)
+	default:
+		_, err = tx.Exec("UPDATE reactions SET value = ? WHERE "+target+" = ? AND user_ID = ?", value, id, userID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return value, tx.Commit()
+}
+
+// ReactHandler likes or dislikes a post or comment, or takes the reaction
+// back, and sends the new counts to everyone who can see them
+func ReactHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req ReactRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permReact)
+	if !ok {
+		return
+	}
+	if !requireFields(client, env, "reaction", req.Reaction) {
+		return
+	}
+	value, known := reactionValues[req.Reaction]
+	if !known {
+		sendError(client, env, "Unknown reaction: "+req.Reaction)
+		return
+	}
+	if (req.PostID == 0) == (req.CommentID == 0) {
+		sendError(client, env, "Invalid react payload: give either postID or commentID")
+		return
+	}
+
+	target, id, postID := "post_ID", req.PostID, req.PostID
+	var err error
+	if req.CommentID != 0 {
+		target, id = "comment_ID", req.CommentID
+		postID, err = getCommentPost(req.CommentID, db)
+	} else {
+		_, err = getPostAuthor(req.PostID, db)
+	}
+	if err == sql.ErrNoRows {
+		if req.CommentID != 0 {
+			sendError(client, env, "Comment not found")
+		} else {
+			sendError(client, env, "Post not found")
+		}
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	current, err := toggleReaction(db, session.UserID, target, id, value)
+	if err != nil {
+		sendError(client, env, "Failed to save reaction")
+		log.Println("Database error:", err)
+		return
+	}
+	likes, dislikes, err := getReactionCounts(db, target, id)
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	counts := map[string]interface{}{
+		"postID":   postID,
+		"likes":    likes,
+		"dislikes": dislikes,
+	}
+	if req.CommentID != 0 {
+		counts["commentID"] = req.CommentID
+	}
+
+	// The response also tells the user which reaction of theirs is left
+	responseData := map[string]interface{}{"reaction": ""}
+	for name, v := range reactionValues {
+		if v == current {
+			responseData["reaction"] = name
+		}
+	}
+	for key, v := range counts {
+		responseData[key] = v
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "reacted", ID: env.ID, Success: true, Message: "Reaction saved", Data: responseData})
+
+	// Post counts are shown in the feed, comment counts only on the post page
+	if req.CommentID != 0 {
+		hub.Publish(postTopic(postID), "reactionsUpdated", counts)
+	} else {
+		hub.Broadcast("reactionsUpdated", counts)
+	}
+}
+
+// maxEmojiRunes bounds the length of a message reaction. Emojis joined with
+// zero width joiners and modifiers take several runes.
+const maxEmojiRunes = 10
+
+// validEmoji reports whether s looks like a single emoji: a short run of
+// symbols and joiners without letters or spaces
+func validEmoji(s string) bool {
+	if !utf8.ValidString(s) || utf8.RuneCountInString(s) > maxEmojiRunes {
+		return false
+	}
+	symbol := false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsSpace(r) {
+			return false
+		}
+		if unicode.Is(unicode.So, r) {
+			symbol = true
+		}
+	}
+	return symbol
+}
+
+// toggleMessageReaction attaches an emoji to a message for userID, or takes it
+// back when the user already attached it
+func toggleMessageReaction(db *sql.DB, userID, messageID int, emoji string) error {
+	result, err := db.Exec("DELETE FROM message_reactions WHERE message_ID = ? AND user_ID = ? AND emoji = ?", messageID, userID, emoji)
+	if err != nil {
+		return err
+	}
+	if removed, err := result.RowsAffected(); err != nil || removed > 0 {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO message_reactions (message_ID, user_ID, emoji) VALUES (?, ?, ?)", messageID, userID, emoji)
+	return err
+}
+
+// MessageReactionHandler attaches an emoji to a private message, or takes it
+// back, and pushes the message's reactions to both participants
+func MessageReactionHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req MessageReactionRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permReact)
+	if !ok {
+		return
+	}
+	if !requireFields(client, env, "emoji", req.Emoji) {
+		return
+	}
+	if !validEmoji(req.Emoji) {
+		sendError(client, env, "Reactions must be a single emoji")
+		return
+	}
+
+	// Only the sender and the receiver can see a message, so only they can react to it
+	message, err := GetMessageByID(db, int64(req.MessageID))
+	if err == sql.ErrNoRows || (err == nil && message.Sender != session.Username && message.Receiver != session.Username) {
+		sendError(client, env, "Message not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	peer := message.Receiver
+	if peer == session.Username {
+		peer = message.Sender
+	}
+	peerID, err := getUserID(peer, db)
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	if err := toggleMessageReaction(db, session.UserID, message.ID, req.Emoji); err != nil {
+		sendError(client, env, "Failed to save reaction")
+		log.Println("Database error:", err)
+		return
+	}
+	message, err = GetMessageByID(db, int64(message.ID))
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"messageID": message.ID,
+		"reactions": message.Reactions,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "messageReacted", ID: env.ID, Success: true, Message: "Reaction saved", Data: responseData})
+	hub.SendToUsers([]int{session.UserID, peerID}, "messageReactionsUpdated", responseData)
+}
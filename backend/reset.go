@@ -0,0 +1,260 @@
+package forum
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordResetLifetime is how long a password reset link can be used
+var PasswordResetLifetime = time.Hour
+
+// BaseURL is where users reach the forum, used for the links in emails
+var BaseURL = "http://localhost:8090"
+
+// passwordResetInterval is how long a user waits before another reset mail
+// is sent, so the form cannot be used to flood someone's inbox
+const passwordResetInterval = time.Minute
+
+var errInvalidResetToken = errors.New("This reset link is invalid or has expired")
+
+// passwordResetRequestedMessage answers every reset request, so the form does
+// not tell whether an account exists
+const passwordResetRequestedMessage = "If the account exists, a link to reset its password was sent to its email"
+
+// generateResetToken returns a random token for a reset link
+func generateResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashResetToken is how a token is stored, so the reset_tokens table alone
+// cannot be used to reset passwords
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createPasswordReset stores a new reset token for the user and returns it.
+// Earlier tokens of the user stop working. An empty token means one was
+// issued less than passwordResetInterval ago and no new one is made.
+func createPasswordReset(db *sql.DB, userID int) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var recent int
+	err = tx.QueryRow("SELECT COUNT(*) FROM reset_tokens WHERE user_ID = ? AND created_at > ?",
+		userID, now.Add(-passwordResetInterval).Unix()).Scan(&recent)
+	if err != nil {
+		return "", err
+	}
+	if recent > 0 {
+		return "", nil
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE reset_tokens SET used_at = ? WHERE user_ID = ? AND used_at IS NULL", now.Unix(), userID); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("INSERT INTO reset_tokens (user_ID, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		userID, hashResetToken(token), now.Unix(), now.Add(PasswordResetLifetime).Unix())
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+// resetPassword uses up a reset token to set a new password and ends all of
+// the user's sessions. The IDs of the ended sessions are returned.
+func resetPassword(db *sql.DB, token, password string) ([]int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	var userID int
+	err = tx.QueryRow(`
+		SELECT t.user_ID
+		FROM reset_tokens AS t
+		INNER JOIN users AS u ON t.user_ID = u.user_ID
+		WHERE t.token_hash = ? AND t.used_at IS NULL AND t.expires_at > ? AND u.deleted_at IS NULL
+	`, hashResetToken(token), now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, errInvalidResetToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The token is used up with every other one the user still had
+	if _, err := tx.Exec("UPDATE reset_tokens SET used_at = ? WHERE user_ID = ? AND used_at IS NULL", now, userID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE user_ID = ?", hashedPassword, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT session_ID FROM sessions WHERE user_ID = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	var sessionIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_ID = ?", userID); err != nil {
+		return nil, err
+	}
+
+	return sessionIDs, tx.Commit()
+}
+
+// passwordResetMail is the mail carrying a reset link
+func passwordResetMail(email, username, token string) Mail {
+	link := strings.TrimSuffix(BaseURL, "/") + "/reset-password?token=" + url.QueryEscape(token)
+	return Mail{
+		To:      email,
+		Subject: "Reset your forum password",
+		Body: "Hi " + username + ",\n\n" +
+			"someone asked to reset the password of your forum account. To choose a new\n" +
+			"password, open this link within " + PasswordResetLifetime.String() + ":\n\n" +
+			link + "\n\n" +
+			"The link works once. If you did not ask for it, you can ignore this mail.\n",
+	}
+}
+
+// PasswordResetHTTPHandler mails a reset link to the account with the posted
+// username or email. The answer is the same whether the account exists or not.
+func PasswordResetHTTPHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, Response{Type: "Error", Success: false, Message: "Method not allowed"})
+		return
+	}
+
+	var req struct {
+		Identifier string `json:"identifier"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Identifier) == "" {
+		writeJSON(w, http.StatusBadRequest, Response{Type: "Error", Success: false, Message: "Invalid message format"})
+		return
+	}
+
+	identifier := strings.ToLower(strings.TrimSpace(req.Identifier))
+	var userID int
+	var email, username string
+	err := db.QueryRow("SELECT user_ID, email, username FROM users WHERE (LOWER(email) = ? OR LOWER(username) = ?) AND deleted_at IS NULL",
+		identifier, identifier).Scan(&userID, &email, &username)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Database error:", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "Error", Success: false, Message: "Database error"})
+		return
+	}
+
+	if err == nil {
+		token, err := createPasswordReset(db, userID)
+		if err != nil {
+			log.Println("Database error:", err)
+			writeJSON(w, http.StatusInternalServerError, Response{Type: "Error", Success: false, Message: "Database error"})
+			return
+		}
+		if token != "" {
+			// Sending can be slow; answering first keeps the timing from telling the account exists
+			mail := passwordResetMail(email, username, token)
+			go func() {
+				if err := Mailer.Send(mail); err != nil {
+					log.Println("Failed to send password reset mail:", err)
+				}
+			}()
+		}
+	}
+
+	writeJSON(w, http.StatusOK, Response{Type: "passwordResetRequested", Success: true, Message: passwordResetRequestedMessage})
+}
+
+// PasswordResetConfirmHTTPHandler sets the new password posted with a reset
+// token. Every session of the user ends, including the one of this browser.
+func PasswordResetConfirmHTTPHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, Response{Type: "Error", Success: false, Message: "Method not allowed"})
+		return
+	}
+
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeJSON(w, http.StatusBadRequest, Response{Type: "Error", Success: false, Message: "Invalid message format"})
+		return
+	}
+	if req.Password == "" {
+		writeJSON(w, http.StatusBadRequest, Response{Type: "Error", Success: false, Message: "Invalid message format: missing password"})
+		return
+	}
+
+	sessionIDs, err := resetPassword(db, req.Token, req.Password)
+	if err == errInvalidResetToken {
+		writeJSON(w, http.StatusBadRequest, Response{Type: "Error", Success: false, Message: err.Error()})
+		return
+	} else if err != nil {
+		log.Println("Database error:", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "Error", Success: false, Message: "Database error"})
+		return
+	}
+	for _, id := range sessionIDs {
+		hub.revokeSession(id)
+	}
+	clearSessionCookie(w, r)
+
+	usersOnline, err := GetAllOnlineUsers(db)
+	if err != nil {
+		log.Println("Failed to get all online users:", err)
+	} else {
+		hub.Broadcast("updatAllUsersOnline", struct {
+			AllUsersOnline []OnlineUser `json:"usersOnline"`
+		}{
+			AllUsersOnline: usersOnline,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, Response{Type: "passwordReset", Success: true, Message: "Password changed, you can log in with it now"})
+}
@@ -0,0 +1,143 @@
+package forum
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Roles a user can have, stored in users.role
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permission is something a role allows a user to do
+type Permission string
+
+const (
+	// permRead allows reading posts, comments and search results
+	permRead Permission = "read"
+	// permPost allows writing, editing and deleting one's own posts
+	permPost Permission = "post"
+	// permComment allows commenting and deleting one's own comments
+	permComment Permission = "comment"
+	// permReact allows liking posts and comments and reacting to messages
+	permReact Permission = "react"
+	// permMessage allows sending and reading private messages
+	permMessage Permission = "message"
+	// permReport allows reporting posts, comments and messages to moderators
+	permReport Permission = "report"
+	// permSessions allows listing and ending one's own sessions
+	permSessions Permission = "sessions"
+	// permProfile allows changing one's own profile and privacy settings
+	permProfile Permission = "profile"
+	// permModerate allows deleting anyone's posts and comments, locking posts
+	// and working through reports
+	permModerate Permission = "moderate"
+	// permManageRoles allows changing the role of other users
+	permManageRoles Permission = "manageRoles"
+	// permBanUsers allows banning and suspending users
+	permBanUsers Permission = "banUsers"
+	// permManageCategories allows creating, renaming, ordering and archiving
+	// categories
+	permManageCategories Permission = "manageCategories"
+)
+
+// memberPermissions are the permissions every role has
+var memberPermissions = []Permission{permRead, permPost, permComment, permReact, permMessage, permReport, permSessions, permProfile}
+
+// rolePermissions lists what each role may do. Unknown roles may do nothing.
+var rolePermissions = map[string][]Permission{
+	RoleUser:      memberPermissions,
+	RoleModerator: withPermissions(memberPermissions, permModerate),
+	RoleAdmin:     withPermissions(memberPermissions, permModerate, permManageRoles, permBanUsers, permManageCategories),
+}
+
+// withPermissions returns a copy of base with extra added
+func withPermissions(base []Permission, extra ...Permission) []Permission {
+	return append(append([]Permission{}, base...), extra...)
+}
+
+// hasPermission reports whether role allows perm
+func hasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// validRole reports whether role is one of the known roles
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// requirePermission is requireSession for handlers whose action needs perm.
+// When the user's role does not allow it an error is sent and false returned.
+func requirePermission(client *Client, db *sql.DB, env Envelope, perm Permission) (*Session, bool) {
+	session, ok := requireSession(client, db, env)
+	if !ok {
+		return nil, false
+	}
+	if !hasPermission(session.Role, perm) {
+		log.Printf("Rejected %s frame: %s (%s) lacks permission %s\n", env.Type, session.Username, session.Role, perm)
+		sendError(client, env, "Permission denied")
+		return nil, false
+	}
+	return session, true
+}
+
+// SetRoleHandler lets an admin change the role of another user
+func SetRoleHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req SetRoleRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permManageRoles)
+	if !ok {
+		return
+	}
+	if !requireFields(client, env, "username", req.Username, "role", req.Role) {
+		return
+	}
+	if !validRole(req.Role) {
+		sendError(client, env, "Unknown role: "+req.Role)
+		return
+	}
+
+	userID, username, err := getUser(req.Username, db)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "User not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	// Admins cannot demote themselves, so the forum is never left without one
+	if strings.EqualFold(username, session.Username) {
+		sendError(client, env, "You cannot change your own role")
+		return
+	}
+
+	if _, err := db.Exec("UPDATE users SET role = ? WHERE user_ID = ?", req.Role, userID); err != nil {
+		sendError(client, env, "Failed to change role")
+		log.Println("Database error:", err)
+		return
+	}
+	if err := logModeration(db, session.UserID, "setRole", "user", userID, req.Role); err != nil {
+		log.Println("Failed to write moderation log:", err)
+	}
+
+	responseData := map[string]interface{}{
+		"username": username,
+		"role":     req.Role,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "roleChanged", ID: env.ID, Success: true, Message: "Role changed", Data: responseData})
+	hub.SendToUser(userID, "roleChanged", responseData)
+}
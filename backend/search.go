@@ -0,0 +1,149 @@
+package forum
+
+import (
+	"database/sql"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// SearchEnabled is set when the database has a full-text search index, see
+// database.SetupSearch
+var SearchEnabled = false
+
+const (
+	// defaultSearchResults is how many results search returns when the request does not say
+	defaultSearchResults = 20
+
+	// maxSearchResults caps the limit a client may ask for
+	maxSearchResults = 50
+
+	// Snippets mark matches with these control characters, which are turned
+	// into <mark> tags once the rest of the snippet is escaped
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// SearchResult is a post, comment or message matching a search. Snippet is
+// HTML with the matching words wrapped in <mark> tags.
+type SearchResult struct {
+	Kind    string `json:"kind"`
+	ID      int    `json:"id"`
+	PostID  int    `json:"postID,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Peer    string `json:"peer,omitempty"`
+	Snippet string `json:"snippet"`
+}
+
+// searchQuery turns user input into an FTS5 query matching every word, so
+// quotes and operators typed by the user are searched for literally
+func searchQuery(input string) string {
+	words := strings.Fields(input)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// Search finds the posts, comments and messages matching the input, best
+// match first. Messages are only searched when username sent or received them.
+func Search(db *sql.DB, username, input string, limit int) ([]SearchResult, error) {
+	query := `
+		SELECT 'post', p.post_ID, p.post_ID, p.title, '',
+		       snippet(posts_fts, -1, :start, :end, '…', 16), bm25(posts_fts) AS rank
+		FROM posts_fts
+		INNER JOIN posts AS p ON p.post_ID = posts_fts.rowid
+		WHERE posts_fts MATCH :query AND p.deleted_at IS NULL
+		UNION ALL
+		SELECT 'comment', c.comment_ID, c.post_ID, p.title, '',
+		       snippet(comments_fts, 0, :start, :end, '…', 16), bm25(comments_fts) AS rank
+		FROM comments_fts
+		INNER JOIN comments AS c ON c.comment_ID = comments_fts.rowid
+		INNER JOIN posts AS p ON p.post_ID = c.post_ID
+		WHERE comments_fts MATCH :query AND c.deleted_at IS NULL AND p.deleted_at IS NULL
+		UNION ALL
+		SELECT 'message', m.message_ID, 0, '', CASE WHEN m.sender = :user THEN m.receiver ELSE m.sender END,
+		       snippet(messages_fts, 0, :start, :end, '…', 16), bm25(messages_fts) AS rank
+		FROM messages_fts
+		INNER JOIN private_messages AS m ON m.message_ID = messages_fts.rowid
+		WHERE messages_fts MATCH :query AND (m.sender = :user OR m.receiver = :user)
+		ORDER BY rank
+		LIMIT :limit
+	`
+	rows, err := db.Query(query,
+		sql.Named("query", searchQuery(input)),
+		sql.Named("user", username),
+		sql.Named("start", matchStart),
+		sql.Named("end", matchEnd),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]SearchResult, 0)
+	for rows.Next() {
+		var result SearchResult
+		var rank float64
+		err := rows.Scan(&result.Kind, &result.ID, &result.PostID, &result.Title, &result.Peer, &result.Snippet, &rank)
+		if err != nil {
+			return nil, err
+		}
+		result.Snippet = highlight(result.Snippet)
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// highlight escapes a snippet and turns its match markers into <mark> tags
+func highlight(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, matchStart, "<mark>")
+	return strings.ReplaceAll(snippet, matchEnd, "</mark>")
+}
+
+// SearchHandler sends the results of a full-text search over WebSocket
+func SearchHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req SearchRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permRead)
+	if !ok {
+		return
+	}
+	if !requireFields(client, env, "query", req.Query) {
+		return
+	}
+	if !SearchEnabled {
+		sendError(client, env, "Search is not available on this server")
+		return
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultSearchResults
+	} else if limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+
+	results, err := Search(db, session.Username, req.Query, limit)
+	if err != nil {
+		sendError(client, env, "Failed to search")
+		log.Println("Search error:", err)
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"query":   req.Query,
+		"results": results,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "searchResults", ID: env.ID, Success: true, Message: "Search results", Data: responseData})
+}
@@ -0,0 +1,203 @@
+package forum
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sessionCookieName is the cookie holding the session token in the browser
+const sessionCookieName = "session_token"
+
+// SessionLifetime is how long a session stays valid without WebSocket activity.
+// Every frame from an authenticated connection pushes the expiry forward again.
+var SessionLifetime = 15 * time.Minute
+
+// touchSession slides the expiry of an unexpired session forward. Expired
+// sessions are left alone so they cannot be revived.
+func touchSession(token string, db *sql.DB) error {
+	now := time.Now()
+	_, err := db.Exec("UPDATE sessions SET expires_at = ? WHERE token = ? AND expires_at > ?", now.Add(SessionLifetime).Unix(), token, now.Unix())
+	return err
+}
+
+// StartSessionReaper deletes expired sessions every interval and tells the
+// connected clients when that changed who is online. It blocks, so run it
+// in its own goroutine.
+func StartSessionReaper(db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := DeleteExpiredSessions(db)
+		if err != nil {
+			log.Println("Error deleting expired sessions:", err)
+			continue
+		}
+		if deleted == 0 {
+			continue
+		}
+		log.Printf("Reaped %d expired sessions\n", deleted)
+
+		usersOnline, err := GetAllOnlineUsers(db)
+		if err != nil {
+			log.Println("Error fetching online users:", err)
+			continue
+		}
+		responseData := struct {
+			AllUsersOnline []OnlineUser `json:"usersOnline"`
+		}{
+			AllUsersOnline: usersOnline,
+		}
+		hub.Broadcast("updatAllUsersOnline", responseData)
+	}
+}
+
+// secureCookie tells whether the session cookie may only travel over HTTPS.
+// That holds when the request came over TLS or the forum is served from an
+// https BaseURL behind a proxy; plain http on localhost keeps working.
+func secureCookie(r *http.Request) bool {
+	return r.TLS != nil || strings.HasPrefix(strings.ToLower(BaseURL), "https://")
+}
+
+// setSessionCookie hands the session token to the browser. The cookie is
+// HttpOnly so scripts cannot read it; the server decides when it expires.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   secureCookie(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookie tells the browser to forget the session token
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureCookie(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionFromRequest returns the active session named by the request's cookie.
+// Sessions of banned users are not restored, like requireSession rejects them.
+func sessionFromRequest(r *http.Request, db *sql.DB) (*Session, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+	session, err := GetSessionByToken(cookie.Value, db)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Database error while restoring session:", err)
+		}
+		return nil, false
+	}
+	ban, err := getActiveBan(db, session.UserID)
+	if err != nil {
+		log.Println("Database error while checking bans:", err)
+		return nil, false
+	}
+	if ban != nil {
+		return nil, false
+	}
+	return session, true
+}
+
+// writeJSON sends a JSON body with the given status code
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("Error writing JSON response:", err)
+	}
+}
+
+// LoginHTTPHandler checks the credentials posted as JSON and sets the
+// session cookie used to restore the login on reload and reconnect
+func LoginHTTPHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, Response{Type: "Error", Success: false, Message: "Method not allowed"})
+		return
+	}
+
+	var credentials struct {
+		Identifier string `json:"identifier"`
+		Password   string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		writeJSON(w, http.StatusBadRequest, Response{Type: "Error", Success: false, Message: "Invalid message format"})
+		return
+	}
+
+	user, err := checkCredentials(credentials.Identifier, credentials.Password, db)
+	var ban *Ban
+	if err == errUserNotFound || err == errInvalidPassword {
+		writeJSON(w, http.StatusUnauthorized, Response{Type: "Error", Success: false, Message: err.Error()})
+		return
+	} else if errors.As(err, &ban) {
+		writeJSON(w, http.StatusForbidden, Response{Type: "Error", Success: false, Message: err.Error()})
+		return
+	} else if err != nil {
+		log.Println("Database error while retrieving user:", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "Error", Success: false, Message: "Database error"})
+		return
+	}
+
+	session, err := startSession(user.ID, r.UserAgent(), db)
+	if err != nil {
+		log.Println("Database error:", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "Error", Success: false, Message: "Database error"})
+		return
+	}
+
+	setSessionCookie(w, r, session.Token)
+	writeJSON(w, http.StatusOK, SuccessResponse{Type: "Login", Success: true, Message: "Login successful", Data: map[string]interface{}{
+		"loggedInUsername": session.Username,
+		"isAuthenticated":  true,
+	}})
+}
+
+// SessionHTTPHandler reports the user behind the session cookie (GET) or
+// ends that session and clears the cookie (DELETE)
+func SessionHTTPHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	switch r.Method {
+	case http.MethodGet:
+		session, ok := sessionFromRequest(r, db)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, Response{Type: "Error", Success: false, Message: "Not authenticated"})
+			return
+		}
+		writeJSON(w, http.StatusOK, SuccessResponse{Type: "Session", Success: true, Message: "Session active", Data: map[string]interface{}{
+			"loggedInUsername": session.Username,
+			"isAuthenticated":  true,
+		}})
+
+	case http.MethodDelete:
+		if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+			if _, err := db.Exec("DELETE FROM sessions WHERE token = ?", cookie.Value); err != nil {
+				log.Println("Database error:", err)
+				writeJSON(w, http.StatusInternalServerError, Response{Type: "Error", Success: false, Message: "Failed to delete session"})
+				return
+			}
+		}
+		clearSessionCookie(w, r)
+		writeJSON(w, http.StatusOK, Response{Type: "userLogout", Success: true, Message: "User logout successful"})
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, Response{Type: "Error", Success: false, Message: "Method not allowed"})
+	}
+}
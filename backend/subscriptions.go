@@ -0,0 +1,197 @@
+package forum
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// getCategorySubscriptions lists the IDs of the categories a user subscribed to
+func getCategorySubscriptions(db *sql.DB, userID int) ([]int, error) {
+	rows, err := db.Query(`
+		SELECT cs.category_ID
+		FROM category_subscriptions AS cs
+		INNER JOIN categories AS c ON cs.category_ID = c.category_ID
+		WHERE cs.user_ID = ?
+		ORDER BY c.position, c.category_ID
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// getCategorySubscribers lists the users subscribed to any of the categories
+func getCategorySubscribers(db *sql.DB, categoryIDs []int) ([]int, error) {
+	if len(categoryIDs) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(categoryIDs))
+	for i, id := range categoryIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := db.Query("SELECT DISTINCT user_ID FROM category_subscriptions WHERE category_ID IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// notifyNewPost sends a new post to the users subscribed to its categories,
+// to the connections following those categories' topics and to the author's
+// other connections, instead of to everyone
+func notifyNewPost(db *sql.DB, post Post, categoryIDs []int, authorID int) error {
+	subscribers, err := getCategorySubscribers(db, categoryIDs)
+	if err != nil {
+		return err
+	}
+	topics := make([]string, len(categoryIDs))
+	for i, id := range categoryIDs {
+		topics[i] = categoryTopic(id)
+	}
+	hub.Notify(topics, append(subscribers, authorID), "newPost", map[string]interface{}{
+		"post":        FeedPost{Post: post},
+		"categoryIDs": categoryIDs,
+	})
+	return nil
+}
+
+// getPostCategoryIDs lists the IDs of the categories a post is in
+func getPostCategoryIDs(db *sql.DB, postID int) ([]int, error) {
+	rows, err := db.Query("SELECT category_ID FROM post_categories WHERE post_ID = ?", postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// notifyPostChange sends a change to a post to the connections showing it:
+// those following the post or its categories and the users subscribed to its
+// categories. The client whose request made the change got the response
+// instead and is left out.
+func notifyPostChange(db *sql.DB, client *Client, postID int, messagetype string, data interface{}) error {
+	categoryIDs, err := getPostCategoryIDs(db, postID)
+	if err != nil {
+		return err
+	}
+	subscribers, err := getCategorySubscribers(db, categoryIDs)
+	if err != nil {
+		return err
+	}
+	topics := []string{postTopic(postID)}
+	for _, id := range categoryIDs {
+		topics = append(topics, categoryTopic(id))
+	}
+	hub.NotifyExcept(client, topics, subscribers, messagetype, data)
+	return nil
+}
+
+// sendCategorySubscriptions answers a subscription change with the user's
+// subscriptions and updates the user's other connections with them
+func sendCategorySubscriptions(client *Client, db *sql.DB, env Envelope, userID int, message string) {
+	subscriptions, err := getCategorySubscriptions(db, userID)
+	if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	responseData := map[string]interface{}{
+		"subscriptions": subscriptions,
+	}
+	SendWebSocketMessageSuccess(client, SuccessResponse{Type: "categorySubscriptions", ID: env.ID, Success: true, Message: message, Data: responseData})
+	hub.SendToUserExcept(userID, client, "categorySubscriptions", responseData)
+}
+
+// SubscribeCategoryHandler subscribes the user to a category, so its new
+// posts are in the user's feed and are sent to the user as they are written
+func SubscribeCategoryHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req CategorySubscriptionRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permRead)
+	if !ok {
+		return
+	}
+
+	category, err := getCategory(db, req.CategoryID)
+	if err == sql.ErrNoRows {
+		sendError(client, env, "Category not found")
+		return
+	} else if err != nil {
+		sendError(client, env, "Database error")
+		log.Println("Database error:", err)
+		return
+	}
+	// Archived categories get no new posts to follow
+	if category.Archived {
+		sendError(client, env, errArchivedCategory.Error())
+		return
+	}
+
+	_, err = db.Exec("INSERT OR IGNORE INTO category_subscriptions (user_ID, category_ID, created_at) VALUES (?, ?, ?)",
+		session.UserID, req.CategoryID, time.Now().UTC().Format(timestampFormat))
+	if err != nil {
+		sendError(client, env, "Failed to subscribe")
+		log.Println("Database error:", err)
+		return
+	}
+	sendCategorySubscriptions(client, db, env, session.UserID, "Subscribed to "+category.Category)
+}
+
+// UnsubscribeCategoryHandler ends the user's subscription to a category
+func UnsubscribeCategoryHandler(client *Client, r *http.Request, db *sql.DB, env Envelope) {
+	var req CategorySubscriptionRequest
+	if !decodePayload(client, env, &req) {
+		return
+	}
+	session, ok := requirePermission(client, db, env, permRead)
+	if !ok {
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM category_subscriptions WHERE user_ID = ? AND category_ID = ?", session.UserID, req.CategoryID)
+	if err != nil {
+		sendError(client, env, "Failed to unsubscribe")
+		log.Println("Database error:", err)
+		return
+	}
+	if removed, err := result.RowsAffected(); err != nil || removed == 0 {
+		sendError(client, env, "Not subscribed to this category")
+		return
+	}
+	sendCategorySubscriptions(client, db, env, session.UserID, "Unsubscribed")
+}
@@ -2,11 +2,10 @@ package forum
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"sync"
+	"strings"
 
 	"github.com/gorilla/websocket"
 )
@@ -16,27 +15,28 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
-// Declare a mutex to safely manage the list of connected clients
-var clientsMutex sync.Mutex
-var clients = make(map[*websocket.Conn]struct{})
-
 func HandleWebSocket(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	client := newClient(conn)
 	defer func() {
-		clientsMutex.Lock()
-		delete(clients, conn)
-		clientsMutex.Unlock()
-		conn.Close()
+		hub.unregister(client)
+		client.drop()
 	}()
 
-	// Add the new connection to the list
-	clientsMutex.Lock()
-	clients[conn] = struct{}{}
-	clientsMutex.Unlock()
+	// Add the new connection to the hub
+	hub.register(client)
+
+	// All writes to the connection happen on this goroutine
+	go client.writePump()
+
+	// Restore the login from the session cookie, if the browser has one
+	if session, ok := sessionFromRequest(r, db); ok {
+		sendLoginData(client, db, Envelope{}, session, "Login", "Session restored")
+	}
 
 	// Handle WebSocket messages
 	for {
@@ -45,112 +45,166 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 			log.Println(err)
 			return
 		}
-		fmt.Printf("Received message: %s\n", p)
-		// Handle different message types (text, binary, etc.) if needed
-		if messageType == websocket.TextMessage {
-			// Parse and handle the message as needed
-			var message map[string]interface{}
-			if err := json.Unmarshal(p, &message); err != nil {
-				log.Println(err)
-				continue
-			}
 
-			messageType, ok := message["message"].(string)
-			log.Print(messageType)
-			if !ok {
-				log.Println("Invalid message format")
-				continue
+		// Any activity keeps the connection's session alive
+		if session := hub.session(client); session != nil {
+			if err := touchSession(session.Token, db); err != nil {
+				log.Println("Error extending session:", err)
 			}
+		}
 
-			// Route the message to the appropriate handler
-			switch messageType {
-			case "register":
-				RegisterHandler(conn, r, db, message)
-
-			case "login":
-				LoginHandler(conn, r, db, message)
-			case "homePage":
-				// Get needed data for the home page
-				allUsernames, err := GetAllUsernames(db)
-				if err != nil {
-					SendWebSocketMessage(conn, Response{Success: false, Message: "Failed to get all usernames"})
-					return
-				}
-
-				allPosts, err := GetAllPosts(db)
-				if err != nil {
-					SendWebSocketMessage(conn, Response{Success: false, Message: "Failed to get all posts"})
-					return
-				}
-				allCategories, err := GetCategories(db)
-				if err != nil {
-					SendWebSocketMessage(conn, Response{Success: false, Message: "Failed to get all categories"})
-					return
-				}
-				allComments, err := GetAllComments(db)
-				if err != nil {
-					SendWebSocketMessage(conn, Response{Success: false, Message: "Failed to get all comments"})
-					return
-				}
-				usersOnline, err := GetAllOnlineUsers(db)
-				if err != nil {
-					SendWebSocketMessage(conn, Response{Success: false, Message: "Failed to get all online users"})
-					return
-				}
-				// Send the data back to the frontend
-				responseData := struct {
-					AllUsernames   []string     `json:"allUsernames"`
-					AllPosts       []Post       `json:"allPosts"`
-					AllCategories  []Category   `json:"allCategories"`
-					AllComments    []Comment    `json:"allComments"`
-					AllUsersOnline []OnlineUser `json:"usersOnline"`
-				}{
-					AllUsernames:   allUsernames,
-					AllPosts:       allPosts,
-					AllCategories:  allCategories,
-					AllComments:    allComments,
-					AllUsersOnline: usersOnline,
-				}
-
-				SendWebSocketMessageSuccess(conn, SuccessResponse{Type: "allData", Success: true, Message: "Home Page Data", Data: responseData})
-				BroadcastChanges(conn, "homePageUpdate", responseData)
-
-			case "createPost":
-				CreatePostHandler(conn, r, db, message)
-			case "submitComment":
-				SubmitCommentHandler(conn, r, db, message)
-
-			case "userLogout":
-				DeleteSessionHandler(conn, r, db, message)
-			case "newMessage":
-				SubmitMessageHandler(conn, r, db, message)
-
-			}
+		// Handle different message types (text, binary, etc.) if needed
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		env, err := parseEnvelope(p)
+		if err != nil {
+			log.Println(err)
+			sendError(client, env, err.Error())
+			continue
+		}
+		// Only the type is logged, payloads can hold passwords
+		log.Print(env.Type)
+
+		// Route the message to the appropriate handler
+		switch env.Type {
+		case "register":
+			RegisterHandler(client, r, db, env)
+		case "login":
+			LoginHandler(client, r, db, env)
+		case "homePage":
+			HomePageHandler(client, r, db, env)
+		case "getFeed":
+			FeedHandler(client, r, db, env)
+		case "search":
+			SearchHandler(client, r, db, env)
+		case "createPost":
+			CreatePostHandler(client, r, db, env)
+		case "editPost":
+			EditPostHandler(client, r, db, env)
+		case "getPostRevisions":
+			PostRevisionsHandler(client, r, db, env)
+		case "deletePost":
+			DeletePostHandler(client, r, db, env)
+		case "lockPost":
+			LockPostHandler(client, r, db, env)
+		case "deleteComment":
+			DeleteCommentHandler(client, r, db, env)
+		case "submitComment":
+			SubmitCommentHandler(client, r, db, env)
+		case "getComments":
+			CommentsHandler(client, r, db, env)
+		case "react":
+			ReactHandler(client, r, db, env)
+		case "userLogout":
+			DeleteSessionHandler(client, r, db, env)
+		case "newMessage":
+			SubmitMessageHandler(client, r, db, env)
+		case "getConversation":
+			GetConversationHandler(client, r, db, env)
+		case "reactMessage":
+			MessageReactionHandler(client, r, db, env)
+		case "listSessions":
+			ListSessionsHandler(client, r, db, env)
+		case "revokeSession":
+			RevokeSessionHandler(client, r, db, env)
+		case "report":
+			ReportHandler(client, r, db, env)
+		case "listReports":
+			ListReportsHandler(client, r, db, env)
+		case "resolveReport", "dismissReport":
+			CloseReportHandler(client, r, db, env)
+		case "getModerationLog":
+			ModerationLogHandler(client, r, db, env)
+		case "banUser":
+			BanUserHandler(client, r, db, env)
+		case "unbanUser":
+			UnbanUserHandler(client, r, db, env)
+		case "setRole":
+			SetRoleHandler(client, r, db, env)
+		case "createCategory":
+			CreateCategoryHandler(client, r, db, env)
+		case "updateCategory":
+			UpdateCategoryHandler(client, r, db, env)
+		case "reorderCategories":
+			ReorderCategoriesHandler(client, r, db, env)
+		case "archiveCategory":
+			ArchiveCategoryHandler(client, r, db, env)
+		case "getProfile":
+			ProfileHandler(client, r, db, env)
+		case "updatePrivacy":
+			UpdatePrivacyHandler(client, r, db, env)
+		case "updateProfile":
+			UpdateProfileHandler(client, r, db, env)
+		case "changePassword":
+			ChangePasswordHandler(client, r, db, env)
+		case "deleteAccount":
+			DeleteAccountHandler(client, r, db, env)
+		case "subscribeCategory":
+			SubscribeCategoryHandler(client, r, db, env)
+		case "unsubscribeCategory":
+			UnsubscribeCategoryHandler(client, r, db, env)
+		case "subscribe":
+			SubscribeHandler(client, r, db, env)
+		case "unsubscribe":
+			UnsubscribeHandler(client, r, db, env)
+		default:
+			sendError(client, env, fmt.Sprintf("Unknown message type %q", env.Type))
 		}
 	}
 }
 
-type WebSocketUpdate struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
+// bindSession associates an authenticated session with a connection
+func bindSession(client *Client, session *Session) {
+	hub.setSession(client, session)
+}
+
+// unbindSession drops the session associated with a connection
+func unbindSession(client *Client) {
+	hub.setSession(client, nil)
 }
 
-// Broadcast changes to all connected clients
-func BroadcastChanges(conn *websocket.Conn, messagetype string, data interface{}) {
-	clientsMutex.Lock()
-	defer clientsMutex.Unlock()
-	// Create a WebSocketUpdate message
-	updateMessage := WebSocketUpdate{
-		Type: messagetype,
-		Data: data,
+// requireSession returns the session bound to the connection after checking
+// that its token is still valid. On failure an error is sent to the client.
+func requireSession(client *Client, db *sql.DB, env Envelope) (*Session, bool) {
+	session := hub.session(client)
+	if session == nil {
+		sendError(client, env, "Not authenticated")
+		return nil, false
 	}
 
-	// Iterate over all connected clients and send them the update
-	for client := range clients {
-		err := client.WriteJSON(updateMessage)
-		if err != nil {
-			log.Println("Error sending update to client:", err)
-			// Handle error as needed (e.g., remove the client from the list)
+	current, err := GetSessionByToken(session.Token, db)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Database error while validating session:", err)
 		}
+		unbindSession(client)
+		sendError(client, env, "Session expired, please log in again")
+		return nil, false
+	}
+
+	// Banned users are logged out when banned, this catches any session left over
+	ban, err := getActiveBan(db, current.UserID)
+	if err != nil {
+		log.Println("Database error while checking bans:", err)
+		sendError(client, env, "Database error")
+		return nil, false
+	}
+	if ban != nil {
+		unbindSession(client)
+		sendError(client, env, ban.Error())
+		return nil, false
+	}
+	return current, true
+}
+
+// checkIdentity rejects frames whose identity field names someone other than
+// the authenticated user. An empty claim is accepted.
+func checkIdentity(client *Client, env Envelope, session *Session, claimed string) bool {
+	if claimed == "" || strings.EqualFold(claimed, session.Username) {
+		return true
 	}
+	log.Printf("Rejected %s frame: %s does not match session user %s\n", env.Type, claimed, session.Username)
+	sendError(client, env, "Identity mismatch")
+	return false
 }
@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strings"
@@ -13,7 +14,10 @@ import (
 const createtables string = `
 CREATE TABLE IF NOT EXISTS categories (
     category_ID INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
-    category TEXT NOT NULL
+    category TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    position INTEGER NOT NULL DEFAULT 0,
+    archived_at TEXT
 );
 
 CREATE TABLE IF NOT EXISTS users (
@@ -25,7 +29,13 @@ CREATE TABLE IF NOT EXISTS users (
     password TEXT NOT NULL,
     age INTEGER NOT NULL,
     gender TEXT NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    role TEXT NOT NULL DEFAULT 'user',
+    show_name INTEGER NOT NULL DEFAULT 0,
+    show_email INTEGER NOT NULL DEFAULT 0,
+    show_age INTEGER NOT NULL DEFAULT 0,
+    show_gender INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT
 );
 
 CREATE TABLE IF NOT EXISTS posts (
@@ -34,25 +44,127 @@ CREATE TABLE IF NOT EXISTS posts (
     title TEXT NOT NULL,
     content TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    edited_at TEXT,
+    deleted_at TEXT,
+    locked_at TEXT,
     FOREIGN KEY (user_ID) REFERENCES users (user_ID)
 );
 
+CREATE TABLE IF NOT EXISTS post_revisions (
+    revision_ID INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    post_ID INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    content TEXT NOT NULL,
+    categories TEXT NOT NULL,
+    written_at TEXT NOT NULL,
+    replaced_at TEXT NOT NULL,
+    FOREIGN KEY (post_ID) REFERENCES posts (post_ID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_revisions_post ON post_revisions (post_ID);
+
 CREATE TABLE IF NOT EXISTS comments (
     comment_ID INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     post_ID INTEGER NOT NULL,
     user_ID INTEGER NOT NULL,
     content TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TEXT,
+    parent_ID INTEGER,
     FOREIGN KEY (post_ID) REFERENCES posts (post_ID),
+    FOREIGN KEY (user_ID) REFERENCES users (user_ID),
+    FOREIGN KEY (parent_ID) REFERENCES comments (comment_ID)
+);
+
+CREATE TABLE IF NOT EXISTS reactions (
+    reaction_ID INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    user_ID INTEGER NOT NULL,
+    post_ID INTEGER,
+    comment_ID INTEGER,
+    value INTEGER NOT NULL CHECK (value IN (1, -1)),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((post_ID IS NULL) != (comment_ID IS NULL)),
+    FOREIGN KEY (user_ID) REFERENCES users (user_ID),
+    FOREIGN KEY (post_ID) REFERENCES posts (post_ID),
+    FOREIGN KEY (comment_ID) REFERENCES comments (comment_ID)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reactions_post ON reactions (post_ID, user_ID) WHERE post_ID IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reactions_comment ON reactions (comment_ID, user_ID) WHERE comment_ID IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS reports (
+    report_ID INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    reporter_ID INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    target_ID INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'open',
+    created_at TEXT NOT NULL,
+    closed_by INTEGER,
+    closed_at TEXT,
+    note TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (reporter_ID) REFERENCES users (user_ID),
+    FOREIGN KEY (closed_by) REFERENCES users (user_ID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status, report_ID);
+CREATE INDEX IF NOT EXISTS idx_reports_target ON reports (kind, target_ID);
+
+CREATE TABLE IF NOT EXISTS moderation_log (
+    log_ID INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    moderator_ID INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    target_ID INTEGER NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL,
+    FOREIGN KEY (moderator_ID) REFERENCES users (user_ID)
+);
+
+CREATE TABLE IF NOT EXISTS bans (
+    ban_ID INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    user_ID INTEGER NOT NULL,
+    banned_by INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    until TEXT,
+    lifted_at TEXT,
+    FOREIGN KEY (user_ID) REFERENCES users (user_ID),
+    FOREIGN KEY (banned_by) REFERENCES users (user_ID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_bans_user ON bans (user_ID);
+
+CREATE TABLE IF NOT EXISTS category_subscriptions (
+    user_ID INTEGER NOT NULL,
+    category_ID INTEGER NOT NULL,
+    created_at TEXT NOT NULL,
+    PRIMARY KEY (user_ID, category_ID),
+    FOREIGN KEY (user_ID) REFERENCES users (user_ID),
+    FOREIGN KEY (category_ID) REFERENCES categories (category_ID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_category_subscriptions_category ON category_subscriptions (category_ID);
+
+CREATE TABLE IF NOT EXISTS reset_tokens (
+    reset_ID INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    user_ID INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at INTEGER,
     FOREIGN KEY (user_ID) REFERENCES users (user_ID)
 );
 
+CREATE INDEX IF NOT EXISTS idx_reset_tokens_user ON reset_tokens (user_ID);
+
 CREATE TABLE IF NOT EXISTS sessions (
     session_ID INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     token TEXT NOT NULL,
     user_ID INTEGER NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     expires_at INTEGER NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
     FOREIGN KEY (user_ID) REFERENCES users (user_ID)
 );
 
@@ -70,8 +182,88 @@ CREATE TABLE IF NOT EXISTS private_messages (
     content TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
+
+CREATE TABLE IF NOT EXISTS message_reactions (
+    reaction_ID INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    message_ID INTEGER NOT NULL,
+    user_ID INTEGER NOT NULL,
+    emoji TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (message_ID, user_ID, emoji),
+    FOREIGN KEY (message_ID) REFERENCES private_messages (message_ID),
+    FOREIGN KEY (user_ID) REFERENCES users (user_ID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_private_messages_conversation ON private_messages (sender, receiver, created_at);
+CREATE INDEX IF NOT EXISTS idx_posts_created_at ON posts (created_at);
+CREATE INDEX IF NOT EXISTS idx_comments_post ON comments (post_ID);
 `
 
+// migrations bring databases created by older versions of the forum up to
+// date with createtables. PRAGMA user_version counts how many have been applied;
+// new databases are created from createtables and start fully migrated.
+var migrations = []string{
+	// Session expiry used to be stored as a timestamp string and is now a Unix time
+	`DELETE FROM sessions WHERE typeof(expires_at) != 'integer'`,
+	// Users can be logged in on several devices, each session remembers its browser
+	`ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+	// Chat history is read a page at a time, newest first, per conversation
+	`CREATE INDEX IF NOT EXISTS idx_private_messages_conversation ON private_messages (sender, receiver, created_at)`,
+	// The feed is read a page at a time in created_at order, with comment counts
+	`CREATE INDEX IF NOT EXISTS idx_posts_created_at ON posts (created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_post ON comments (post_ID)`,
+	// Posts can be edited, earlier versions are kept in post_revisions
+	`ALTER TABLE posts ADD COLUMN edited_at TEXT`,
+	// Deleted posts and comments are kept as tombstones
+	`ALTER TABLE posts ADD COLUMN deleted_at TEXT`,
+	`ALTER TABLE comments ADD COLUMN deleted_at TEXT`,
+	// Comments can reply to other comments of the same post
+	`ALTER TABLE comments ADD COLUMN parent_ID INTEGER REFERENCES comments (comment_ID)`,
+	// Users have a role, the seeded admin and moderator accounts get theirs
+	`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user'`,
+	`UPDATE users SET role = username WHERE username IN ('admin', 'moderator')`,
+	// Moderators can lock posts against new comments
+	`ALTER TABLE posts ADD COLUMN locked_at TEXT`,
+	// Admins manage categories: they describe, order and archive them
+	`ALTER TABLE categories ADD COLUMN description TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE categories ADD COLUMN position INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE categories ADD COLUMN archived_at TEXT`,
+	// Users choose which registration fields their public profile shows
+	`ALTER TABLE users ADD COLUMN show_name INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN show_email INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN show_age INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN show_gender INTEGER NOT NULL DEFAULT 0`,
+	// Deleted accounts keep their row so their username stays taken
+	`ALTER TABLE users ADD COLUMN deleted_at TEXT`,
+	// Post timestamps are stored in one format, so they order correctly as text.
+	// The seeded posts name their zone as " UTC", which SQLite does not read.
+	`UPDATE posts SET created_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', REPLACE(created_at, ' UTC', '')), created_at)
+	  WHERE created_at NOT LIKE '%Z'`,
+	`UPDATE post_revisions SET written_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', REPLACE(written_at, ' UTC', '')), written_at)
+	  WHERE written_at NOT LIKE '%Z'`,
+	// Comments are stored in the same format, so activity lists mixing them
+	// with posts order correctly
+	`UPDATE comments SET created_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', REPLACE(created_at, ' UTC', '')), created_at)
+	  WHERE created_at NOT LIKE '%Z'`,
+}
+
+// migrate applies the migrations the database has not seen yet
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		if _, err := db.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func OpenDB() (*sql.DB, error) {
 	dbPath := "./database/database.db"
 
@@ -123,6 +315,11 @@ func OpenDB() (*sql.DB, error) {
 				}
 			}
 		}
+
+		// The tables above already have the latest schema
+		if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+			return nil, err
+		}
 		return db, nil
 	}
 
@@ -130,5 +327,13 @@ func OpenDB() (*sql.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	// Create tables added since the database was made, then upgrade the old ones
+	if _, err := db.Exec(createtables); err != nil {
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
 	return db, nil
 }
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// searchtables holds the full-text search index. Each FTS5 table indexes the
+// text of its content table and is kept in sync by triggers.
+const searchtables string = `
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5 (
+    title, content, content='posts', content_rowid='post_ID'
+);
+CREATE VIRTUAL TABLE IF NOT EXISTS comments_fts USING fts5 (
+    content, content='comments', content_rowid='comment_ID'
+);
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5 (
+    content, content='private_messages', content_rowid='message_ID'
+);
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+    INSERT INTO posts_fts (rowid, title, content) VALUES (new.post_ID, new.title, new.content);
+END;
+CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+    INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.post_ID, old.title, old.content);
+END;
+CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+    INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.post_ID, old.title, old.content);
+    INSERT INTO posts_fts (rowid, title, content) VALUES (new.post_ID, new.title, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_fts_insert AFTER INSERT ON comments BEGIN
+    INSERT INTO comments_fts (rowid, content) VALUES (new.comment_ID, new.content);
+END;
+CREATE TRIGGER IF NOT EXISTS comments_fts_delete AFTER DELETE ON comments BEGIN
+    INSERT INTO comments_fts (comments_fts, rowid, content) VALUES ('delete', old.comment_ID, old.content);
+END;
+CREATE TRIGGER IF NOT EXISTS comments_fts_update AFTER UPDATE OF content ON comments BEGIN
+    INSERT INTO comments_fts (comments_fts, rowid, content) VALUES ('delete', old.comment_ID, old.content);
+    INSERT INTO comments_fts (rowid, content) VALUES (new.comment_ID, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON private_messages BEGIN
+    INSERT INTO messages_fts (rowid, content) VALUES (new.message_ID, new.content);
+END;
+CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON private_messages BEGIN
+    INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.message_ID, old.content);
+END;
+CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON private_messages BEGIN
+    INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.message_ID, old.content);
+    INSERT INTO messages_fts (rowid, content) VALUES (new.message_ID, new.content);
+END;
+`
+
+// searchTriggers are the triggers created by searchtables
+var searchTriggers = []string{
+	"posts_fts_insert", "posts_fts_delete", "posts_fts_update",
+	"comments_fts_insert", "comments_fts_delete", "comments_fts_update",
+	"messages_fts_insert", "messages_fts_delete", "messages_fts_update",
+}
+
+// SetupSearch creates the full-text search index. FTS5 is only compiled into
+// SQLite when the forum is built with -tags sqlite_fts5; without it an error
+// is returned and the database is left usable without search.
+func SetupSearch(db *sql.DB) error {
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		return err
+	}
+	if !fts5 {
+		// Triggers left by an earlier build with search would make every
+		// write to the indexed tables fail
+		dropSearchTriggers(db)
+		return errors.New("SQLite was built without FTS5")
+	}
+
+	var triggers int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE '%_fts_%'").Scan(&triggers)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(searchtables); err != nil {
+		return err
+	}
+
+	// Rows written while the triggers were missing are not indexed yet
+	if triggers < len(searchTriggers) {
+		for _, table := range []string{"posts_fts", "comments_fts", "messages_fts"} {
+			if _, err := db.Exec("INSERT INTO " + table + " (" + table + ") VALUES ('rebuild')"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func dropSearchTriggers(db *sql.DB) {
+	for _, trigger := range searchTriggers {
+		db.Exec("DROP TRIGGER IF EXISTS " + trigger)
+	}
+}
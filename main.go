@@ -1,26 +1,74 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	forum "forum/backend"
 	"forum/database"
 	"log"
 	"net/http"
+	"os"
+	"time"
 )
 
 func main() {
+	sessionLifetime := flag.Duration("session-lifetime", forum.SessionLifetime, "how long a session stays valid without activity")
+	reapInterval := flag.Duration("session-reap-interval", time.Minute, "how often expired sessions are deleted")
+	resetLifetime := flag.Duration("password-reset-lifetime", forum.PasswordResetLifetime, "how long a password reset link can be used")
+	baseURL := flag.String("base-url", forum.BaseURL, "address users reach the forum at, used for links in emails")
+	smtpAddr := flag.String("smtp-addr", "", "host:port of the SMTP server that sends emails; without it emails go to -mail-file")
+	smtpFrom := flag.String("smtp-from", "forum@localhost", "sender address of emails")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username, the password is read from SMTP_PASSWORD")
+	mailFile := flag.String("mail-file", "", "file emails are appended to when no SMTP server is set; when empty only their recipient and subject are logged")
+	flag.Parse()
+	forum.SessionLifetime = *sessionLifetime
+	forum.PasswordResetLifetime = *resetLifetime
+	forum.BaseURL = *baseURL
+	if *smtpAddr != "" {
+		forum.Mailer = forum.SMTPMailSender{
+			Addr:     *smtpAddr,
+			From:     *smtpFrom,
+			Username: *smtpUsername,
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	} else {
+		forum.Mailer = forum.FileMailSender{Path: *mailFile}
+	}
+
 	db, err := database.OpenDB()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
+	if err := database.SetupSearch(db); err != nil {
+		log.Println("Search is disabled, build with -tags sqlite_fts5 to enable it:", err)
+	} else {
+		forum.SearchEnabled = true
+	}
+	go forum.StartSessionReaper(db, *reapInterval)
+
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./frontend/main.html")
 	})
+	http.HandleFunc("/reset-password", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./frontend/reset_password.html")
+	})
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		forum.HandleWebSocket(w, r, db)
 	})
+	http.HandleFunc("/api/login", func(w http.ResponseWriter, r *http.Request) {
+		forum.LoginHTTPHandler(w, r, db)
+	})
+	http.HandleFunc("/api/session", func(w http.ResponseWriter, r *http.Request) {
+		forum.SessionHTTPHandler(w, r, db)
+	})
+	http.HandleFunc("/api/password-reset", func(w http.ResponseWriter, r *http.Request) {
+		forum.PasswordResetHTTPHandler(w, r, db)
+	})
+	http.HandleFunc("/api/password-reset/confirm", func(w http.ResponseWriter, r *http.Request) {
+		forum.PasswordResetConfirmHTTPHandler(w, r, db)
+	})
 
 	port := "8090"
 	fmt.Printf("Listening on port %v\n", port)
@@ -3,6 +3,10 @@ import CreatePost from "./views/CreatePost.js";
 import PostView from "./views/PostView.js";
 import Chats from "./views/Chats.js";
 import ErrorPage from "./views/ErrorPage.js";
+import Search from "./views/Search.js";
+import Moderation from "./views/Moderation.js";
+import Profile from "./views/Profile.js";
+import { watchPost, watchProfile } from "./ws.js";
 
 const pathToRegex = (path) =>
   new RegExp("^" + path.replace(/\//g, "\\/").replace(/:\w+/g, "(.+)") + "$");
@@ -33,6 +37,9 @@ export const router = async () => {
     { path: "/create-post", view: CreatePost },
     { path: "/post/:id", view: PostView },
     { path: "/chats", view: Chats },
+    { path: "/search", view: Search },
+    { path: "/moderation", view: Moderation },
+    { path: "/profile/:username", view: Profile },
     { path: "/error", view: ErrorPage },
 
   ];
@@ -58,6 +65,11 @@ export const router = async () => {
 
   let view = new match.route.view(getParams(match)); // Assign the view here
 
+  // An open post is updated live, e.g. when it or one of its comments is deleted
+  watchPost(match.route.view === PostView ? Number(view.postId) : null);
+  // A profile is loaded each time it is opened
+  watchProfile(match.route.view === Profile ? view.username : null);
+
   document.querySelector("#app").innerHTML = await view.updateApp();
   await view.pageAction(); 
 };
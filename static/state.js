@@ -1,16 +1,36 @@
 // Define the initial state
 const initialState = {
     loggedInUsername: null,
-    allPosts: { post: {} },
+    role: "user",
+    allPosts: [],
+    feedPosts: [],
+    feedCursor: null,
+    feedCategory: "",
+    feedSort: "newest",
+    feedSubscribed: false,
+    categorySubscriptions: [],
     AllUsernames: null,
     isAuthenticated: false,
     allCategories: { category: {} },
-    allComments: { comment: {} },
     NotifyAllUsersOnlineStatus: { onlineUsers: {} },
     errorMessage: null,
-    allMessagesForUser : { message: {} },
+    lastMessageAt: {},
     chatOpen : false,
     selectedChatUsername: null,
+    chatMessages: [],
+    chatHasMore: false,
+    chatScrollFrom: null,
+    sessions: [],
+    editingPostID: null,
+    commentTree: { postID: null, comments: [], maxDepth: 0 },
+    replyingTo: null,
+    postRevisions: { postID: null, revisions: [] },
+    searchQuery: "",
+    searchResults: [],
+    moderationStatus: "open",
+    moderationReports: [],
+    moderationLog: [],
+    profile: null,
     sendTypingNotification: false
 };
 
@@ -1,3 +1,13 @@
+// escapeHTML makes text safe to put into the markup the views build as strings
+export function escapeHTML(value) {
+    return String(value ?? "")
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&quot;")
+        .replace(/'/g, "&#39;");
+}
+
 export default class {
     constructor(params) {
         this.params = params;
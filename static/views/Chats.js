@@ -51,42 +51,39 @@ export default class extends AbstractView {
         
 
         function getLastMessageTimestamp(username) {
-            const loggedInUsername = state.loggedInUsername;
+            // The server sends the time of the latest message of every conversation on login
+            const lastMessageAt = state.lastMessageAt[username];
         
-            // Filter messages based on the sender or receiver being the loggedInUsername
-            const userMessages = state.allMessagesForUser.filter(message =>
-                (message.sender === loggedInUsername && message.receiver === username) ||
-                (message.sender === username && message.receiver === loggedInUsername)
-            );
-        
-            // Find the latest message timestamp among the filtered messages
-            const lastMessage = userMessages.reduce((latestMessage, currentMessage) => {
-                const currentTimestamp = new Date(currentMessage.created_at).getTime();
-        
-                return (!latestMessage || currentTimestamp > new Date(latestMessage.created_at).getTime()) ?
-                    currentMessage :
-                    latestMessage;
-            }, null);
-        
-            return lastMessage ? new Date(lastMessage.created_at).getTime() : null;
+            return lastMessageAt ? new Date(lastMessageAt).getTime() : null;
         }
         
+        // Emojis offered for reacting to a message
+        const reactionEmojis = ["👍", "❤️", "😂", "😮", "😢"];
+
+        // Define a function to create the reactions of a message and the buttons to add one
+        function createMessageReactions(message) {
+            const reactions = (message.reactions || []).map((reaction) => {
+                const mine = reaction.users.includes(state.loggedInUsername) ? "mine" : "";
+                return `<button class="message-reaction ${mine}" data-message-id="${message.message_ID}" data-emoji="${reaction.emoji}" title="${reaction.users.join(", ")}">${reaction.emoji} ${reaction.users.length}</button>`;
+            });
+            const picker = reactionEmojis.map((emoji) =>
+                `<button class="message-reaction pick" data-message-id="${message.message_ID}" data-emoji="${emoji}">${emoji}</button>`
+            );
+            return `
+                <span class="message-reactions">
+                    ${reactions.join("")}
+                    <span class="reaction-picker">${picker.join("")}</span>
+                </span>
+            `;
+        }
+
         function displayChat() {
             if (!state || !state.chatOpen) {
                 return '<div class="chat-message">Select a chat</div>';
             }
         
-            // Get the selected chat's messages
-            const selectedUsername = state.selectedChatUsername;
-            const selectedChatMessages = state.allMessagesForUser.filter((message) =>
-                (message.sender === state.loggedInUsername && message.receiver === selectedUsername) ||
-                (message.sender === selectedUsername && message.receiver === state.loggedInUsername)
-            );
-        
-            // Load only the last 10 messages if there are more than 10
-            const messagesToDisplay = selectedChatMessages.slice(-10);
-        
-            const messages = messagesToDisplay.map((message) => {
+            // The selected chat's messages are loaded from the server a page at a time
+            const messages = state.chatMessages.map((message) => {
                 const { sender, content, created_at } = message;
         
                 // Convert the timestamp to a Date object
@@ -107,6 +104,8 @@ export default class extends AbstractView {
                         <span class="sender">${sender}</span>
                         <span class="content-message">${content}</span>
                         <span class="time">${formattedTime}</span>
+                        ${createMessageReactions(message)}
+                        ${sender !== state.loggedInUsername ? `<button class="report-message" data-message-id="${message.message_ID}">Report</button>` : ''}
                     </p>
                 `;
             });
@@ -116,10 +115,16 @@ export default class extends AbstractView {
             const chatContainer = messages.join("");
 
             
-            // Scroll to the bottom
+            // Scroll to the bottom, or keep the view in place when older messages were added on top
             setTimeout(() => {
                 const chatElement = document.getElementById('all-messages');
-                chatElement.scrollTop = chatElement.scrollHeight;
+                const chatScrollFrom = getState().chatScrollFrom;
+                if (chatScrollFrom !== null) {
+                    chatElement.scrollTop = chatElement.scrollHeight - chatScrollFrom;
+                    updateState({ chatScrollFrom: null });
+                } else {
+                    chatElement.scrollTop = chatElement.scrollHeight;
+                }
             }, 0);
         
             return chatContainer;
@@ -186,14 +191,38 @@ export default class extends AbstractView {
                 const selectedUsername = this.dataset.username;
                 updateState({
                     chatOpen: true,
-                    selectedChatUsername: selectedUsername
+                    selectedChatUsername: selectedUsername,
+                    chatMessages: [],
+                    chatHasMore: false,
+                    chatScrollFrom: null
                 });
 
+                // Load the latest messages, older ones follow when scrolling up
+                sendMessage("getConversation", { peer: selectedUsername });
                 router();
 
             });
         });
 
+        // Clicking an emoji adds the user's reaction, or takes it back
+        document.querySelectorAll(".message-reaction").forEach((button) => {
+            button.addEventListener("click", function () {
+                sendMessage("reactMessage", {
+                    messageID: Number(this.dataset.messageId),
+                    emoji: this.dataset.emoji
+                });
+            });
+        });
+
+        document.querySelectorAll(".report-message").forEach((button) => {
+            button.addEventListener("click", function () {
+                const reason = prompt("Why are you reporting this message?");
+                if (reason) {
+                    sendMessage("report", { messageID: Number(this.dataset.messageId), reason: reason });
+                }
+            });
+        });
+
         backHome.addEventListener("click", function () {
             navigateTo("/");
         });
@@ -209,14 +238,11 @@ export default class extends AbstractView {
 
                 if (messageInput && loggedInUsername && selectedChatUsername) {
                     const newMessage = {
-                        message: "newMessage",
-                        sender: loggedInUsername,
                         receiver: selectedChatUsername,
-                        content: messageInput.value,
-                        created_at: new Date().toISOString()
+                        content: messageInput.value
                     };
 
-                    sendMessage(newMessage);
+                    sendMessage("newMessage", newMessage);
 
                     messageInput.value = "";
                     console.log("Sending message:", newMessage);
@@ -233,14 +259,11 @@ export default class extends AbstractView {
     
                     if (messageInput && loggedInUsername && selectedChatUsername) {
                         const newMessage = {
-                            message: "newMessage",
-                            sender: loggedInUsername,
                             receiver: selectedChatUsername,
-                            content: messageInput.value,
-                            created_at: new Date().toISOString()
+                            content: messageInput.value
                         };
     
-                        sendMessage(newMessage);
+                        sendMessage("newMessage", newMessage);
                             messageInput.value = "";
                         console.log("Sending message:", newMessage);
                     }
@@ -248,53 +271,19 @@ export default class extends AbstractView {
             });
         }
         
-        // Get the selected chat's messages
-        const selectedUsername = state.selectedChatUsername;
-        const selectedChatMessages = state.allMessagesForUser.filter((message) =>
-            (message.sender === state.loggedInUsername && message.receiver === selectedUsername) ||
-            (message.sender === selectedUsername && message.receiver === state.loggedInUsername)
-        );
-        let messagesToShow = 10;
-
         // Add an onscroll event listener to the chat element with debounce
         const chatElement = document.getElementById('all-messages');
         chatElement.onscroll = debounce(function () {
-            // Check if the user has scrolled to the top of the chat
-            if (chatElement.scrollTop === 0) {
-                // If yes, load 10 more messages
-                messagesToShow += 10;
-
-                // Update the messages to display based on the new count
-                const newMessagesToDisplay = selectedChatMessages.slice(-messagesToShow);
-
-                // Map and format the new messages
-                const newMessages = newMessagesToDisplay.map((message) => {
-                    const { sender, content, created_at } = message;
-
-                    // Convert the timestamp to a Date object
-                    const messageDate = new Date(created_at);
-
-                    // Format the date in "hour:minute day/month/year" format
-                    const formattedTime = new Intl.DateTimeFormat('en-US', {
-                        hour: 'numeric',
-                        minute: 'numeric',
-                        hour12: true,
-                        day: 'numeric',
-                        month: 'short',
-                        year: '2-digit'
-                    }).format(messageDate);
-
-                    return `
-                        <p class="chat-message">
-                            <span class="sender">${sender}</span>
-                            <span class="content-message">${content}</span>
-                            <span class="time">${formattedTime}</span>
-                        </p>
-                    `;
+            state = getState();
+            // Check if the user has scrolled to the top of a chat with older messages
+            if (chatElement.scrollTop === 0 && state.chatHasMore && state.chatScrollFrom === null) {
+                // If yes, load 10 more messages from before the oldest one shown
+                updateState({ chatScrollFrom: chatElement.scrollHeight });
+                sendMessage("getConversation", {
+                    peer: state.selectedChatUsername,
+                    before: state.chatMessages[0].message_ID,
+                    limit: 10
                 });
-
-                // Insert the new messages at the beginning of the chat container
-                chatElement.innerHTML = newMessages.join("") + chatElement.innerHTML;
             }
         }, 200); // 200ms debounce time (adjust as needed)
 
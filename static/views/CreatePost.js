@@ -1,7 +1,6 @@
 import AbstractView from "./AbstractView.js";
 import { getState } from '../state.js';
 import { sendMessage } from "../ws.js";
-import { navigateTo } from "../index.js";
 
 export default class extends AbstractView {
     constructor(params) {
@@ -15,7 +14,8 @@ export default class extends AbstractView {
 
         // Define a function to create category checkboxes
         function createCategoryCheckboxes() {
-            const categories = state.allCategories.map((category) => {
+            // Archived categories take no new posts
+            const categories = state.allCategories.filter((category) => !category.archived).map((category) => {
                 return `
                 <div class="checkbox-rect">
                     <input class="checkbox-spin" type="checkbox" id="${category.category}" name="categories[]" value="${category.category}">
@@ -44,7 +44,6 @@ export default class extends AbstractView {
                         <div class="category-choose">
                             ${createCategoryCheckboxes()}
                         </div>
-                        <input type="hidden" id="createdBy" name="createdyBy" value="${state.loggedInUsername}">
                         <input type="text" id="title" name="title" placeholder="Post title ..." required> <br>
                         <input type="text" id="content" name="content" placeholder="Post content ..." required> <br>
                         <div class="submit-post">
@@ -64,18 +63,14 @@ export default class extends AbstractView {
                 e.preventDefault(); // Prevent the default form submission
                 const titleInput = document.getElementById("title");
                 const contentInput = document.getElementById("content");
-                const createdByInput = document.getElementById("createdBy");
                 const selectedCategoriesInput = Array.from(document.querySelectorAll('input[name="categories[]"]:checked'));
 
                 const title = titleInput.value;
                 const content = contentInput.value;
-                const createdBy = createdByInput.value;
                 const selectedCategories = selectedCategoriesInput.map(checkbox => checkbox.value);
 
                 // Prepare the post data
                 const postData = {
-                    message: "createPost",
-                    createdBy: createdBy,
                     title: title,
                     content: content,
                     categories: selectedCategories,
@@ -83,8 +78,8 @@ export default class extends AbstractView {
                 console.log("WebSocket Message:", postData);
 
                 // Send the post data as a JSON string to the WebSocket
-                sendMessage(postData);
-                navigateTo("/");
+                // The home page is shown once the server confirms the post
+                sendMessage("createPost", postData);
             });
         }
     }
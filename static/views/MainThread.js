@@ -1,6 +1,6 @@
 import AbstractView from "./AbstractView.js";
-import { getState } from '../state.js';
-import { sendMessage } from "../ws.js";
+import { getState, updateState } from '../state.js';
+import { sendMessage, loginOverHTTP, setPendingCredentials, requestFeed } from "../ws.js";
 
 export default class extends AbstractView {
     constructor(params) {
@@ -29,7 +29,7 @@ export default class extends AbstractView {
 
                 return `
                     <div class="user-with-indicator">
-                        <p class="category-button category">${username}</p>
+                        <a href="/profile/${encodeURIComponent(username)}" class="category-button category" data-link>${username}</a>
                         ${onlineStatusIndicator}
                     </div>
                 `;
@@ -39,24 +39,66 @@ export default class extends AbstractView {
         }
 
     
+        // Define a function to create the feed filters
+        function createFeedFilters() {
+            const categories = Array.isArray(state.allCategories) ? state.allCategories : [];
+            const categoryOptions = categories.map((category) => {
+                const selected = category.category === state.feedCategory ? "selected" : "";
+                return `<option value="${category.category}" ${selected}>${category.category}</option>`;
+            });
+
+            // The selected category can be subscribed to, unless it is archived
+            const selected = categories.find(category => category.category === state.feedCategory);
+            let followButton = '';
+            if (selected) {
+                const subscribed = state.categorySubscriptions.includes(selected.category_id);
+                if (subscribed || !selected.archived) {
+                    followButton = `<button id="follow-category" data-category-id="${selected.category_id}" data-subscribed="${subscribed}">${subscribed ? "Unsubscribe" : "Subscribe"}</button>`;
+                }
+            }
+
+            return `
+                <div class="feed-filters">
+                    <select id="feed-category">
+                        <option value="">All categories</option>
+                        ${categoryOptions.join("")}
+                    </select>
+                    ${followButton}
+                    <label class="feed-subscribed">
+                        <input type="checkbox" id="feed-subscribed" ${state.feedSubscribed ? "checked" : ""}>
+                        Subscribed only
+                    </label>
+                    <select id="feed-sort">
+                        <option value="newest" ${state.feedSort === "newest" ? "selected" : ""}>Newest first</option>
+                        <option value="oldest" ${state.feedSort === "oldest" ? "selected" : ""}>Oldest first</option>
+                    </select>
+                </div>
+            `;
+        }
+
         // Define a function to create the posts
         function createPosts() {
-            const posts = state.allPosts.map((post) => {
+            const posts = state.feedPosts.map((post) => {
                 const truncatedContent = post.content.slice(0, 100); // Take only the first 100 characters
                 return `
                     <div class="post" data-username="${post.username}" data-category="${post.post_category}" id="post">
                         <div class="post-category">
                             <span>${post.post_category}</span>
                         </div>
-                        <a href="/post/${post.post_id}" class="title" data-link>${post.title} by: ${post.username}</a>
+                        <a href="/post/${post.post_id}" class="title" data-link>${post.title}</a>
+                        <a href="/profile/${encodeURIComponent(post.username)}" class="author" data-link>by: ${post.username}</a>
                         <p class="content">${truncatedContent}...</p>
                         <div class="reactions">
-                            <a href="/post/${post.post_id}" class="comments" data-link></a>
+                            <button class="react-button" data-post-id="${post.post_id}" data-reaction="like">👍 ${post.likes}</button>
+                            <button class="react-button" data-post-id="${post.post_id}" data-reaction="dislike">👎 ${post.dislikes}</button>
+                            <a href="/post/${post.post_id}" class="comments" data-link>${post.comment_count}</a>
                         </div>
                     </div>
                 `;
             });
-            return posts.join("");
+            // Later pages are loaded on request
+            const loadMore = state.feedCursor ? '<button class="load-more" id="load-more">Load more</button>' : '';
+            return posts.join("") + loadMore;
         }
 
         // Generate the HTML based on the user's login status
@@ -74,6 +116,7 @@ export default class extends AbstractView {
                         </div>
                         <div class="all-posts" id="all-posts">
                             <h2 class="posts">Posts</h2>
+                            ${createFeedFilters()}
                             ${createPosts()}
                         </div>
                     </div>
@@ -98,6 +141,46 @@ export default class extends AbstractView {
 
     async pageAction(){
         let state = getState();
+        if (state.isAuthenticated) {
+            const categorySelect = document.getElementById("feed-category");
+            const sortSelect = document.getElementById("feed-sort");
+            const subscribedCheckbox = document.getElementById("feed-subscribed");
+            const followButton = document.getElementById("follow-category");
+            const loadMoreButton = document.getElementById("load-more");
+
+            // Changing a filter starts the feed over from its first page
+            function changeFeed(changes) {
+                updateState({ ...changes, feedPosts: [], feedCursor: null });
+                requestFeed();
+            }
+
+            categorySelect.addEventListener("change", function () {
+                changeFeed({ feedCategory: categorySelect.value });
+            });
+            sortSelect.addEventListener("change", function () {
+                changeFeed({ feedSort: sortSelect.value });
+            });
+            subscribedCheckbox.addEventListener("change", function () {
+                changeFeed({ feedSubscribed: subscribedCheckbox.checked });
+            });
+            if (followButton) {
+                followButton.addEventListener("click", function () {
+                    const type = this.dataset.subscribed === "true" ? "unsubscribeCategory" : "subscribeCategory";
+                    sendMessage(type, { categoryID: Number(this.dataset.categoryId) });
+                });
+            }
+            if (loadMoreButton) {
+                loadMoreButton.addEventListener("click", function () {
+                    requestFeed(true);
+                });
+            }
+            // Counts change once the server has saved the reaction
+            document.querySelectorAll(".react-button").forEach((button) => {
+                button.addEventListener("click", function () {
+                    sendMessage("react", { postID: Number(this.dataset.postId), reaction: this.dataset.reaction });
+                });
+            });
+        }
         if (!state.isAuthenticated) {
             const loginForm = document.querySelector(".form");
             const identifierInput = document.getElementById("identifier");
@@ -109,15 +192,8 @@ export default class extends AbstractView {
 
                 const identifier = identifierInput.value;
                 const password = passwordInput.value;
-                // Prepare the login data
-                const loginData = {
-                    message: "login",
-                    identifier: identifier,
-                    password: password,
-                };
-                console.log("WebSocket Message:", loginData);
-                // Send the login data as a JSON string to the WebSocket
-                sendMessage(loginData);
+                // Log in over HTTP so the session survives a page reload
+                loginOverHTTP(identifier, password);
                 closePopup("loginPopup");
             });
 
@@ -143,7 +219,6 @@ export default class extends AbstractView {
 
                 // Prepare the registration data
                 const registrationData = {
-                    message: "register",
                     email: email,
                     "first-name": firstName,
                     "last-name": lastName,
@@ -155,7 +230,8 @@ export default class extends AbstractView {
                 console.log("WebSocket Message:", registrationData);
 
                 // Send the registration data as a JSON string to the WebSocket
-                sendMessage(registrationData);
+                setPendingCredentials(username, password);
+                sendMessage("register", registrationData);
 
                 // Optionally, you can close the popup here if needed
                 closePopup("signupPopup");
@@ -0,0 +1,213 @@
+import AbstractView, { escapeHTML } from "./AbstractView.js";
+import { getState, updateState } from '../state.js';
+import { sendMessage } from "../ws.js";
+
+export default class extends AbstractView {
+    constructor(params) {
+        super(params);
+        this.setTitle("Moderation");
+    }
+
+    async updateApp() {
+        const state = getState();
+
+        if (state.role !== "moderator" && state.role !== "admin") {
+            return '<div class="post-page"><p class="search-empty">Only moderators can see this page</p></div>';
+        }
+
+        // Define a function to create the reports with the given status
+        function createReports() {
+            if (state.moderationReports.length === 0) {
+                return '<p class="search-empty">No reports</p>';
+            }
+            // Reports show what users wrote, so every value is escaped
+            const reports = state.moderationReports.map((report) => {
+                const target = `${escapeHTML(report.kind)} #${escapeHTML(report.target_id)} by ${escapeHTML(report.author)}`;
+                const link = report.post_id
+                    ? `<a href="/post/${escapeHTML(report.post_id)}" class="title" data-link>${target}</a>`
+                    : `<p class="title">${target}</p>`;
+                const actions = report.status === "open" ? `
+                    <div class="post-actions">
+                        <button class="post-action close-report" data-report-id="${escapeHTML(report.report_id)}" data-decision="resolveReport">Resolve</button>
+                        <button class="post-action close-report" data-report-id="${escapeHTML(report.report_id)}" data-decision="dismissReport">Dismiss</button>
+                    </div>
+                ` : `<p class="revision-info">${escapeHTML(report.status)} by ${escapeHTML(report.closed_by)} ${escapeHTML(report.closed_at)} ${escapeHTML(report.note)}</p>`;
+                return `
+                    <div class="search-result report-card">
+                        ${link}
+                        <p class="content">${escapeHTML(report.content)}</p>
+                        <p class="revision-info">Reported by ${escapeHTML(report.reporter)} ${escapeHTML(report.created_at)}: ${escapeHTML(report.reason)}</p>
+                        ${actions}
+                    </div>
+                `;
+            });
+            return reports.join("");
+        }
+
+        // Define a function to create the audit trail
+        function createLog() {
+            const entries = state.moderationLog.map((entry) => `
+                <p class="revision-info">${escapeHTML(entry.created_at)} · ${escapeHTML(entry.moderator)} · ${escapeHTML(entry.action)} ${escapeHTML(entry.kind)} #${escapeHTML(entry.target_id)} ${escapeHTML(entry.note)}</p>
+            `);
+            return entries.join("");
+        }
+
+        // Define a function to create the forms only admins can use
+        function createAdminTools() {
+            if (state.role !== "admin") {
+                return '';
+            }
+            return `
+                <h2 class="posts">Users</h2>
+                <form class="search-form" id="ban-form">
+                    <input type="text" id="ban-username" placeholder="Username" required />
+                    <input type="text" id="ban-reason" placeholder="Reason" required />
+                    <input type="datetime-local" id="ban-until" title="Suspend until, leave empty to ban" />
+                    <button type="submit">Ban</button>
+                    <button type="button" id="unban">Lift ban</button>
+                </form>
+                <form class="search-form" id="role-form">
+                    <input type="text" id="role-username" placeholder="Username" required />
+                    <select id="role-select">
+                        <option value="user">user</option>
+                        <option value="moderator">moderator</option>
+                        <option value="admin">admin</option>
+                    </select>
+                    <button type="submit">Change role</button>
+                </form>
+                <h2 class="posts">Categories</h2>
+                ${createCategories()}
+                <form class="search-form" id="category-form">
+                    <input type="text" id="category-name" placeholder="Name" required />
+                    <input type="text" id="category-description" placeholder="Description" />
+                    <button type="submit">Add category</button>
+                </form>
+            `;
+        }
+
+        // Define a function to create a form for each category, in their order
+        function createCategories() {
+            const categories = state.allCategories.map((category, index) => `
+                <form class="search-form category-edit" data-category-id="${escapeHTML(category.category_id)}">
+                    <input type="text" class="category-edit-name" value="${escapeHTML(category.category)}" required />
+                    <input type="text" class="category-edit-description" value="${escapeHTML(category.description)}" placeholder="Description" />
+                    <button type="submit">Save</button>
+                    <button type="button" class="category-move" data-index="${index}" data-step="-1" ${index === 0 ? "disabled" : ""}>↑</button>
+                    <button type="button" class="category-move" data-index="${index}" data-step="1" ${index === state.allCategories.length - 1 ? "disabled" : ""}>↓</button>
+                    <button type="button" class="category-archive" data-archived="${category.archived}">${category.archived ? "Restore" : "Archive"}</button>
+                </form>
+            `);
+            return categories.join("");
+        }
+
+        const statuses = ["open", "resolved", "dismissed"].map((status) =>
+            `<option value="${status}" ${state.moderationStatus === status ? "selected" : ""}>${status}</option>`
+        );
+
+        return `
+            <div class="post-page">
+                <div class="back-home-wrap">
+                    <a href="/" class="back-home" data-link>← Back</a>
+                </div>
+                <div class="search-results">
+                    <h2 class="posts">Reports</h2>
+                    <select id="report-status">${statuses.join("")}</select>
+                    ${createReports()}
+                    ${createAdminTools()}
+                    <h2 class="posts">Moderation log</h2>
+                    ${createLog()}
+                </div>
+            </div>
+        `;
+    }
+
+    async pageAction() {
+        const statusSelect = document.getElementById("report-status");
+        if (!statusSelect) {
+            return;
+        }
+
+        statusSelect.addEventListener("change", function () {
+            updateState({ moderationStatus: statusSelect.value, moderationReports: [] });
+            sendMessage("listReports", { status: statusSelect.value });
+        });
+
+        document.querySelectorAll(".close-report").forEach((button) => {
+            button.addEventListener("click", function () {
+                const note = prompt("Note for the moderation log (optional)");
+                if (note === null) {
+                    return;
+                }
+                sendMessage(this.dataset.decision, { reportID: Number(this.dataset.reportId), note: note });
+                sendMessage("getModerationLog");
+            });
+        });
+
+        const banForm = document.getElementById("ban-form");
+        if (banForm) {
+            const username = document.getElementById("ban-username");
+            banForm.addEventListener("submit", function (event) {
+                event.preventDefault();
+                // Without an end time the ban is permanent
+                const until = document.getElementById("ban-until").value;
+                sendMessage("banUser", {
+                    username: username.value,
+                    reason: document.getElementById("ban-reason").value,
+                    until: until ? new Date(until).toISOString() : ""
+                });
+                sendMessage("getModerationLog");
+            });
+            document.getElementById("unban").addEventListener("click", function () {
+                sendMessage("unbanUser", { username: username.value });
+                sendMessage("getModerationLog");
+            });
+
+            const roleForm = document.getElementById("role-form");
+            roleForm.addEventListener("submit", function (event) {
+                event.preventDefault();
+                sendMessage("setRole", {
+                    username: document.getElementById("role-username").value,
+                    role: document.getElementById("role-select").value
+                });
+                sendMessage("getModerationLog");
+            });
+
+            document.getElementById("category-form").addEventListener("submit", function (event) {
+                event.preventDefault();
+                sendMessage("createCategory", {
+                    name: document.getElementById("category-name").value,
+                    description: document.getElementById("category-description").value
+                });
+                sendMessage("getModerationLog");
+            });
+
+            document.querySelectorAll(".category-edit").forEach((form) => {
+                const categoryID = Number(form.dataset.categoryId);
+                form.addEventListener("submit", function (event) {
+                    event.preventDefault();
+                    sendMessage("updateCategory", {
+                        categoryID: categoryID,
+                        name: form.querySelector(".category-edit-name").value,
+                        description: form.querySelector(".category-edit-description").value
+                    });
+                    sendMessage("getModerationLog");
+                });
+                form.querySelector(".category-archive").addEventListener("click", function () {
+                    sendMessage("archiveCategory", { categoryID: categoryID, archived: this.dataset.archived !== "true" });
+                    sendMessage("getModerationLog");
+                });
+            });
+
+            // Moving a category swaps it with its neighbour and sends the whole order
+            document.querySelectorAll(".category-move").forEach((button) => {
+                button.addEventListener("click", function () {
+                    const ids = getState().allCategories.map((category) => category.category_id);
+                    const index = Number(this.dataset.index);
+                    const other = index + Number(this.dataset.step);
+                    [ids[index], ids[other]] = [ids[other], ids[index]];
+                    sendMessage("reorderCategories", { categoryIDs: ids });
+                });
+            });
+        }
+    }
+}
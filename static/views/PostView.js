@@ -1,5 +1,5 @@
 import AbstractView from "./AbstractView.js";
-import { getState } from '../state.js';
+import { getState, updateState } from '../state.js';
 import { sendMessage } from "../ws.js";
 import { navigateTo } from "../index.js";
 
@@ -33,27 +33,94 @@ export default class extends AbstractView {
         
 
         let selectedPost = findPostById(state.allPosts, this.postId);
-        console.log(selectedPost.post_id)
 
-        // Helper function to filter comments for the selected post
-        function filterCommentsForPost(allComments, postId) {
-            console.log("All Comments:", allComments);
-
-            return allComments.filter(comment => comment.post_comment_id === Number(postId));
+        // A post not in the feed arrives with its comments. Once they are
+        // loaded, a missing post was deleted and only a tombstone is shown.
+        if (!selectedPost) {
+            const loaded = state.commentTree.postID === Number(this.postId);
+            return `
+                <div class="post-page" id="post-page">
+                    <div class="back-home-wrap" id="back-home">
+                        <div class="back-home">
+                            <a href="/" class="back-home-btn" id="back-home-btn" data-link>Back on Home Page</a>
+                        </div>
+                    </div>
+                    <div class="info-post">
+                        <p class="content">${loaded ? "This post was deleted." : "Loading post…"}</p>
+                    </div>
+                </div>
+            `;
         }
 
-        // Filter comments for the selected post
-        let commentsForPost = filterCommentsForPost(state.allComments, this.postId);
-        console.log("Comments for post:", commentsForPost)
+        // Moderators and admins may delete anything and lock posts
+        const isModerator = state.role === "moderator" || state.role === "admin";
 
-        // Define a function to create comments
-        function createComments(commentsForPost) {
-            const commentsHTML = commentsForPost.map((comment) => `
-                <div class="comment">
-                    <p class="title"> by: ${comment.username}</p>
+        // The comment tree is loaded from the server when the post is opened
+        const commentTree = state.commentTree.postID === selectedPost.post_id ? state.commentTree : null;
+
+        // Define a function to create the like and dislike buttons of a post or comment
+        function createReactions(item, idAttribute, id) {
+            return `
+                <div class="reactions">
+                    <button class="react-button" ${idAttribute}="${id}" data-reaction="like">👍 ${item.likes}</button>
+                    <button class="react-button" ${idAttribute}="${id}" data-reaction="dislike">👎 ${item.dislikes}</button>
+                </div>
+            `;
+        }
+
+        // Define a function to create a comment with its replies
+        function createComment(comment, depth) {
+            let body;
+            if (comment.deleted) {
+                body = '<p class="content deleted">This comment was deleted.</p>';
+            } else {
+                const deleteButton = comment.username === state.loggedInUsername || isModerator
+                    ? `<button class="post-action delete-comment" data-comment-id="${comment.comment_id}">Delete</button>`
+                    : '';
+                // Replies can only be nested so deep, and locked posts take none
+                const replyButton = depth < commentTree.maxDepth && (!selectedPost.locked || isModerator)
+                    ? `<button class="post-action reply-comment" data-comment-id="${comment.comment_id}">Reply</button>`
+                    : '';
+                const reportButton = comment.username !== state.loggedInUsername
+                    ? `<button class="post-action report-button" data-comment-id="${comment.comment_id}">Report</button>`
+                    : '';
+                body = `
+                    <p class="title"> by: ${comment.username} ${replyButton} ${deleteButton} ${reportButton}</p>
                     <p class="content">${comment.content}</p>
+                    ${createReactions(comment, "data-comment-id", comment.comment_id)}
+                `;
+            }
+
+            const replyForm = state.replyingTo === comment.comment_id ? `
+                <form class="reply-form" data-parent-id="${comment.comment_id}">
+                    <input type="text" class="reply-input" placeholder="Your reply here ..." required>
+                    <input type="submit" value="Reply" class="submit">
+                </form>
+            ` : '';
+
+            const replies = (comment.replies || []).map(reply => createComment(reply, depth + 1)).join("");
+            const hiddenReplies = comment.reply_count - (comment.replies || []).length;
+            const moreReplies = hiddenReplies > 0 ? `<p class="more-replies">${hiddenReplies} more replies</p>` : '';
+
+            return `
+                <div class="comment-thread">
+                    <div class="comment">
+                        ${body}
+                    </div>
+                    ${replyForm}
+                    <div class="comment-replies">
+                        ${replies}
+                        ${moreReplies}
+                    </div>
                 </div>
-            `).join("");
+            `;
+        }
+
+        // Define a function to create comments
+        function createComments() {
+            const commentsHTML = commentTree
+                ? commentTree.comments.map(comment => createComment(comment, 1)).join("")
+                : '<p class="content">Loading comments ...</p>';
 
             return `
                 <div class="post-comments" id="post-comments">
@@ -65,6 +132,9 @@ export default class extends AbstractView {
 
         // Define a function to create the comment form
         function createCommentForm() {
+            if (selectedPost.locked && !isModerator) {
+                return '<p class="login-to">This post is locked, no new comments can be added.</p>';
+            }
             return `
                 <div class="comment-form">
                     <p class="login-to">Leave a Comment</p>
@@ -77,13 +147,83 @@ export default class extends AbstractView {
             `;
         }
         function createPostInfo(selectedPost) {
+            const editedMarker = selectedPost.edited ? '<span class="edited">(edited)</span>' : '';
+            const lockedMarker = selectedPost.locked ? '<span class="edited">(locked)</span>' : '';
+            // Only the author may edit, anyone may look at earlier versions
+            const isAuthor = selectedPost.username === state.loggedInUsername;
+            const editButton = isAuthor ? '<button class="post-action" id="edit-post">Edit</button>' : '';
+            const deleteButton = isAuthor || isModerator ? '<button class="post-action" id="delete-post">Delete</button>' : '';
+            const lockButton = isModerator
+                ? `<button class="post-action" id="lock-post">${selectedPost.locked ? "Unlock" : "Lock"}</button>`
+                : '';
+            const reportButton = !isAuthor ? `<button class="post-action report-button" data-post-id="${selectedPost.post_id}">Report</button>` : '';
+            const historyButton = selectedPost.edited ? '<button class="post-action" id="post-history">History</button>' : '';
             return `
                 <div class="info-post">
                     <div class="post-category">
                         <span>${selectedPost.post_category}</span>
                     </div>
-                    <p class="title">${selectedPost.title} by: ${selectedPost.username}</p>
+                    <p class="title">${selectedPost.title} by: ${selectedPost.username} ${editedMarker} ${lockedMarker}</p>
                     <p class="content">${selectedPost.content}</p>
+                    ${createReactions(selectedPost, "data-post-id", selectedPost.post_id)}
+                    <div class="post-actions">
+                        ${editButton}
+                        ${deleteButton}
+                        ${lockButton}
+                        ${reportButton}
+                        ${historyButton}
+                    </div>
+                </div>
+            `;
+        }
+
+        // Define a function to create the form for editing the post
+        function createEditForm(selectedPost) {
+            const postCategories = selectedPost.post_category.split(" ");
+            // A post can stay in an archived category but not be moved into one
+            const categories = state.allCategories.filter((category) =>
+                !category.archived || postCategories.includes(category.category)
+            ).map((category) => {
+                const checked = postCategories.includes(category.category) ? "checked" : "";
+                return `
+                <div class="checkbox-rect">
+                    <input class="checkbox-spin" type="checkbox" id="edit-${category.category}" name="edit-categories[]" value="${category.category}" ${checked}>
+                    <label for="edit-${category.category}">
+                        ${category.category}
+                    </label>
+                </div>`
+            });
+
+            return `
+                <form class="edit-post-form" id="edit-post-form">
+                    <div class="category-choose">
+                        ${categories.join("")}
+                    </div>
+                    <input type="text" id="edit-title" value="${selectedPost.title}" required> <br>
+                    <input type="text" id="edit-content" value="${selectedPost.content}" required> <br>
+                    <input class="submit" type="submit" value="Save">
+                    <button class="post-action" type="button" id="cancel-edit">Cancel</button>
+                </form>
+            `;
+        }
+
+        // Define a function to create the list of earlier versions
+        function createRevisions(selectedPost) {
+            if (state.postRevisions.postID !== selectedPost.post_id) {
+                return '';
+            }
+            const revisions = state.postRevisions.revisions.map((revision) => `
+                <div class="comment revision">
+                    <p class="title">${revision.title}</p>
+                    <p class="content">${revision.content}</p>
+                    <p class="revision-info">${revision.categories.join(" ")} · written ${revision.written_at}, replaced ${revision.replaced_at}</p>
+                </div>
+            `).join("");
+
+            return `
+                <div class="post-comments">
+                    <p class="all-comments">Earlier versions</p>
+                    ${revisions}
                 </div>
             `;
         }
@@ -96,8 +236,9 @@ export default class extends AbstractView {
                     </div>
                 </div>
                 <div class="post-container">
-                    ${createPostInfo(selectedPost)}
-                    ${createComments(commentsForPost)}
+                    ${state.editingPostID === selectedPost.post_id ? createEditForm(selectedPost) : createPostInfo(selectedPost)}
+                    ${createRevisions(selectedPost)}
+                    ${createComments()}
                     ${createCommentForm()}
                 </div>
             </div>
@@ -105,9 +246,108 @@ export default class extends AbstractView {
     }
     async pageAction() {
         let state = getState();
+        const postId = Number(this.postId);
         if (state.isAuthenticated) {
+            const editButton = document.getElementById("edit-post");
+            const historyButton = document.getElementById("post-history");
+            const editForm = document.getElementById("edit-post-form");
+
+            if (editButton) {
+                editButton.addEventListener("click", function () {
+                    updateState({ editingPostID: postId });
+                    navigateTo(`/post/${postId}`);
+                });
+            }
+            const deleteButton = document.getElementById("delete-post");
+            if (deleteButton) {
+                deleteButton.addEventListener("click", function () {
+                    if (confirm("Delete this post and its comments?")) {
+                        sendMessage("deletePost", { postID: postId });
+                    }
+                });
+            }
+            const lockButton = document.getElementById("lock-post");
+            if (lockButton) {
+                lockButton.addEventListener("click", function () {
+                    const post = getState().allPosts.find(post => post.post_id === postId);
+                    sendMessage("lockPost", { postID: postId, locked: !post.locked });
+                });
+            }
+            document.querySelectorAll(".reply-comment").forEach((button) => {
+                button.addEventListener("click", function () {
+                    updateState({ replyingTo: Number(this.dataset.commentId) });
+                    navigateTo(`/post/${postId}`);
+                });
+            });
+            document.querySelectorAll(".reply-form").forEach((form) => {
+                form.addEventListener("submit", function (e) {
+                    e.preventDefault(); // Prevent the default form submission
+                    // The reply appears in the tree once the server confirms it
+                    sendMessage("submitComment", {
+                        postID: postId,
+                        parentID: Number(this.dataset.parentId),
+                        comment: this.querySelector(".reply-input").value
+                    });
+                    updateState({ replyingTo: null });
+                });
+            });
+            document.querySelectorAll(".report-button").forEach((button) => {
+                button.addEventListener("click", function () {
+                    const reason = prompt("Why are you reporting this?");
+                    if (!reason) {
+                        return;
+                    }
+                    const target = this.dataset.commentId
+                        ? { commentID: Number(this.dataset.commentId) }
+                        : { postID: Number(this.dataset.postId) };
+                    sendMessage("report", { ...target, reason: reason });
+                });
+            });
+            document.querySelectorAll(".react-button").forEach((button) => {
+                button.addEventListener("click", function () {
+                    const target = this.dataset.commentId
+                        ? { commentID: Number(this.dataset.commentId) }
+                        : { postID: Number(this.dataset.postId) };
+                    sendMessage("react", { ...target, reaction: this.dataset.reaction });
+                });
+            });
+            document.querySelectorAll(".delete-comment").forEach((button) => {
+                button.addEventListener("click", function () {
+                    if (confirm("Delete this comment?")) {
+                        sendMessage("deleteComment", { commentID: Number(this.dataset.commentId) });
+                    }
+                });
+            });
+            if (historyButton) {
+                historyButton.addEventListener("click", function () {
+                    sendMessage("getPostRevisions", { postID: postId });
+                });
+            }
+            if (editForm) {
+                document.getElementById("cancel-edit").addEventListener("click", function () {
+                    updateState({ editingPostID: null });
+                    navigateTo(`/post/${postId}`);
+                });
+
+                editForm.addEventListener("submit", function (e) {
+                    e.preventDefault(); // Prevent the default form submission
+                    const categories = Array.from(document.querySelectorAll('input[name="edit-categories[]"]:checked'));
+
+                    // The post is shown again once the server confirms the edit
+                    sendMessage("editPost", {
+                        postID: postId,
+                        title: document.getElementById("edit-title").value,
+                        content: document.getElementById("edit-content").value,
+                        categories: categories.map(checkbox => checkbox.value)
+                    });
+                });
+            }
     
             const commentForm = document.querySelector(".comment-form-submit");
+            // Deleted posts have no comment form, locked ones only for moderators
+            if (!commentForm) {
+                return;
+            }
     
             commentForm.addEventListener("submit", function (e) {
                 e.preventDefault(); // Prevent the default form submission
@@ -116,19 +356,16 @@ export default class extends AbstractView {
     
                 const comment = commentInput.value;
                 const postID = postIDInput.value;
-                const username = state.loggedInUsername;
     
                 // Prepare the comment data
                 const commentData = {
-                    message: "submitComment",
-                    postID: postID,
-                    comment: comment,
-                    username: username
+                    postID: Number(postID),
+                    comment: comment
                 };
                 console.log("WebSocket Message:", commentData);
     
                 // Send the comment data as a JSON string to the WebSocket
-                sendMessage(commentData);
+                sendMessage("submitComment", commentData);
                 navigateTo(`/post/${postID}`);
                 
             });
@@ -0,0 +1,188 @@
+import AbstractView from "./AbstractView.js";
+import { getState } from '../state.js';
+import { sendMessage } from "../ws.js";
+
+export default class extends AbstractView {
+    constructor(params) {
+        super(params);
+        this.username = decodeURIComponent(params.username);
+        this.setTitle(`Profile of ${this.username}`);
+    }
+
+    // Whether the profile in the state is the one this page shows
+    showsLoadedProfile(state) {
+        return state.profile !== null && state.profile.username.toLowerCase() === this.username.toLowerCase();
+    }
+
+    async updateApp() {
+        const state = getState();
+
+        if (!this.showsLoadedProfile(state)) {
+            return '<div class="post-page"><p class="search-empty">Loading profile…</p></div>';
+        }
+        const profile = state.profile;
+
+        // Define a function to create the registration fields the user shares
+        function createDetails() {
+            const details = [];
+            if (profile.first_name || profile.last_name) {
+                details.push(`${profile.first_name} ${profile.last_name}`);
+            }
+            if (profile.age) {
+                details.push(`${profile.age} years old`);
+            }
+            if (profile.gender) {
+                details.push(profile.gender);
+            }
+            if (profile.email) {
+                details.push(profile.email);
+            }
+            return details.map(detail => `<p class="content">${detail}</p>`).join("");
+        }
+
+        // Define a function to create the list of recent posts and comments
+        function createActivity() {
+            if (profile.recent_activity.length === 0) {
+                return '<p class="search-empty">No posts or comments yet</p>';
+            }
+            const activity = profile.recent_activity.map((item) => {
+                const label = item.kind === "comment" ? `Comment on ${item.title}` : item.title;
+                return `
+                    <div class="search-result">
+                        <a href="/post/${item.post_id}" class="title" data-link>${label}</a>
+                        <p class="content">${item.content}</p>
+                        <p class="revision-info">${item.created_at}</p>
+                    </div>
+                `;
+            });
+            return activity.join("");
+        }
+
+        // Define a function to create the privacy settings, only shown on one's own profile
+        function createPrivacyForm() {
+            if (!profile.privacy) {
+                return '';
+            }
+            const fields = [
+                ["showName", "name"],
+                ["showEmail", "email"],
+                ["showAge", "age"],
+                ["showGender", "gender"]
+            ].map(([key, label]) => `
+                <label class="privacy-option">
+                    <input type="checkbox" name="${key}" ${profile.privacy[key] ? "checked" : ""}>
+                    Show my ${label}
+                </label>
+            `);
+            return `
+                <h2 class="posts">Privacy</h2>
+                <form class="search-form" id="privacy-form">
+                    ${fields.join("")}
+                    <button type="submit">Save</button>
+                </form>
+            `;
+        }
+
+        // Define a function to create the account forms, only shown on one's own profile
+        function createAccountForms() {
+            if (!profile.privacy) {
+                return '';
+            }
+            const genders = [
+                ["female", "Female"],
+                ["male", "Male"],
+                ["non-binary", "Non binary"],
+                ["other", "Other"]
+            ].map(([value, label]) =>
+                `<option value="${value}" ${profile.gender.toLowerCase() === value ? "selected" : ""}>${label}</option>`
+            );
+            return `
+                <h2 class="posts">Account</h2>
+                <form class="search-form" id="profile-form">
+                    <input type="text" id="profile-first-name" value="${profile.first_name}" placeholder="First name" required />
+                    <input type="text" id="profile-last-name" value="${profile.last_name}" placeholder="Last name" required />
+                    <input type="email" id="profile-email" value="${profile.email}" placeholder="Email" required />
+                    <select id="profile-gender">${genders.join("")}</select>
+                    <input type="password" id="profile-password" placeholder="Current password, to change the email" />
+                    <button type="submit">Save</button>
+                </form>
+                <form class="search-form" id="password-form">
+                    <input type="password" id="old-password" placeholder="Current password" required />
+                    <input type="password" id="new-password" placeholder="New password" required />
+                    <button type="submit">Change password</button>
+                </form>
+                <form class="search-form" id="delete-account-form">
+                    <input type="password" id="delete-password" placeholder="Password" required />
+                    <button type="submit">Delete account</button>
+                </form>
+            `;
+        }
+
+        return `
+            <div class="post-page">
+                <div class="back-home-wrap">
+                    <a href="/" class="back-home" data-link>← Back</a>
+                </div>
+                <div class="search-results">
+                    <h2 class="posts">${profile.username}</h2>
+                    <p class="revision-info">${profile.role} · joined ${profile.joined_at}</p>
+                    ${createDetails()}
+                    <p class="content">${profile.post_count} posts · ${profile.comment_count} comments</p>
+                    ${createPrivacyForm()}
+                    ${createAccountForms()}
+                    <h2 class="posts">Recent activity</h2>
+                    ${createActivity()}
+                </div>
+            </div>
+        `;
+    }
+
+    async pageAction() {
+        const privacyForm = document.getElementById("privacy-form");
+        if (privacyForm) {
+            privacyForm.addEventListener("submit", function (event) {
+                event.preventDefault();
+                const settings = {};
+                privacyForm.querySelectorAll("input[type=checkbox]").forEach((checkbox) => {
+                    settings[checkbox.name] = checkbox.checked;
+                });
+                sendMessage("updatePrivacy", settings);
+            });
+        }
+
+        const profileForm = document.getElementById("profile-form");
+        if (profileForm) {
+            profileForm.addEventListener("submit", function (event) {
+                event.preventDefault();
+                sendMessage("updateProfile", {
+                    "first-name": document.getElementById("profile-first-name").value,
+                    "last-name": document.getElementById("profile-last-name").value,
+                    email: document.getElementById("profile-email").value,
+                    gender: document.getElementById("profile-gender").value,
+                    currentPassword: document.getElementById("profile-password").value
+                });
+                document.getElementById("profile-password").value = "";
+            });
+
+            const passwordForm = document.getElementById("password-form");
+            passwordForm.addEventListener("submit", function (event) {
+                event.preventDefault();
+                sendMessage("changePassword", {
+                    oldPassword: document.getElementById("old-password").value,
+                    newPassword: document.getElementById("new-password").value
+                });
+                passwordForm.reset();
+            });
+
+            // Deleting the account cannot be undone, so it is confirmed twice
+            const deleteForm = document.getElementById("delete-account-form");
+            deleteForm.addEventListener("submit", function (event) {
+                event.preventDefault();
+                if (!confirm("Delete your account? Your posts, comments and messages will be removed.")) {
+                    return;
+                }
+                sendMessage("deleteAccount", { password: document.getElementById("delete-password").value });
+            });
+        }
+    }
+}
@@ -0,0 +1,89 @@
+import AbstractView, { escapeHTML } from "./AbstractView.js";
+import { getState, updateState } from '../state.js';
+import { navigateTo } from "../index.js";
+import { sendMessage } from "../ws.js";
+
+export default class extends AbstractView {
+    constructor(params) {
+        super(params);
+        this.setTitle("Search");
+    }
+
+    async updateApp() {
+        const state = getState();
+
+        // Define a function to create the search results
+        function createResults() {
+            if (!state.searchQuery) {
+                return '';
+            }
+            if (state.searchResults.length === 0) {
+                return '<p class="search-empty">Nothing found</p>';
+            }
+
+            // Snippets are escaped by the server, only the <mark> tags are HTML;
+            // everything else is escaped here
+            const results = state.searchResults.map((result) => {
+                if (result.kind === "message") {
+                    return `
+                        <div class="search-result">
+                            <a class="title chat-link" data-peer="${escapeHTML(result.peer)}">Message with ${escapeHTML(result.peer)}</a>
+                            <p class="content">${result.snippet}</p>
+                        </div>
+                    `;
+                }
+                const title = escapeHTML(result.title);
+                const label = result.kind === "comment" ? `Comment on ${title}` : title;
+                return `
+                    <div class="search-result">
+                        <a href="/post/${escapeHTML(result.postID)}" class="title" data-link>${label}</a>
+                        <p class="content">${result.snippet}</p>
+                    </div>
+                `;
+            });
+            return results.join("");
+        }
+
+        return `
+            <div class="post-page">
+                <div class="back-home-wrap">
+                    <a href="/" class="back-home" data-link>← Back</a>
+                </div>
+                <form class="search-form" id="search-form">
+                    <input type="search" id="search-input" placeholder="Search posts, comments and messages" value="${escapeHTML(state.searchQuery)}" required />
+                    <button type="submit">Search</button>
+                </form>
+                <div class="search-results">
+                    ${createResults()}
+                </div>
+            </div>
+        `;
+    }
+
+    async pageAction() {
+        const searchForm = document.getElementById("search-form");
+        const searchInput = document.getElementById("search-input");
+
+        searchForm.addEventListener("submit", function (event) {
+            event.preventDefault();
+            updateState({ searchQuery: searchInput.value });
+            sendMessage("search", { query: searchInput.value });
+        });
+
+        // Messages open the conversation they belong to
+        document.querySelectorAll(".chat-link").forEach((link) => {
+            link.addEventListener("click", function () {
+                const peer = this.dataset.peer;
+                updateState({
+                    chatOpen: true,
+                    selectedChatUsername: peer,
+                    chatMessages: [],
+                    chatHasMore: false,
+                    chatScrollFrom: null
+                });
+                sendMessage("getConversation", { peer: peer });
+                navigateTo("/chats");
+            });
+        });
+    }
+}
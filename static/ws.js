@@ -3,8 +3,12 @@ import { router } from './index.js'
 import { navigateTo } from './index.js';
 
 
+// Credentials of a registration in flight, used to get the session cookie once it succeeds
+let pendingCredentials = null;
+
 export function connectWebSocket() {
-    const socket = new WebSocket('ws://localhost:8090/ws');
+    // The session cookie is sent with the upgrade request, so the server restores the login
+    const socket = new WebSocket(`ws://${location.host}/ws`);
 
     socket.addEventListener('open', async (event) => {
         console.log('WebSocket connection opened:', event);
@@ -15,6 +19,15 @@ export function connectWebSocket() {
 
     socket.addEventListener('close', (event) => {
         console.log('WebSocket connection closed:', event);
+        // Reconnect unless this socket was already replaced on purpose
+        if (event.target === socket) {
+            // Requests sent on this socket will never be answered, and its subscriptions are gone
+            pendingRequests.clear();
+            subscribedPostID = null;
+            subscribedCategoryTopics.clear();
+            loadedProfile = null;
+            setTimeout(reconnectWebSocket, 1000);
+        }
     });
 
     socket.addEventListener('message', (event) => {
@@ -24,16 +37,254 @@ export function connectWebSocket() {
     return socket;
 }
 // Establish WebSocket connection
-export const socket = connectWebSocket();
+export let socket = connectWebSocket();
+
+// Reopen the WebSocket connection, e.g. after the session cookie changed
+export function reconnectWebSocket() {
+    const previous = socket;
+    socket = connectWebSocket();
+    if (previous.readyState === WebSocket.OPEN || previous.readyState === WebSocket.CONNECTING) {
+        previous.close();
+    }
+}
+
+// Log in over HTTP so the server can set the HttpOnly session cookie,
+// then reconnect so the WebSocket picks the session up
+export async function loginOverHTTP(identifier, password) {
+    try {
+        const response = await fetch('/api/login', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ identifier: identifier, password: password }),
+        });
+        const data = await response.json();
+        if (!response.ok) {
+            updateState({
+                errorMessage: data.message
+            });
+            navigateTo("/error");
+            return;
+        }
+        reconnectWebSocket();
+    } catch (error) {
+        console.error('Login request failed:', error);
+    }
+}
+
+// Remember the credentials of a registration so the cookie can be set once it succeeds
+export function setPendingCredentials(identifier, password) {
+    pendingCredentials = { identifier: identifier, password: password };
+}
+
 
+// Version of the WebSocket protocol this client speaks, see docs/PROTOCOL.md
+const PROTOCOL_VERSION = 1;
+let lastRequestID = 0;
 
-export function sendMessage(message) {
+// Requests that have not been answered yet, by id
+const pendingRequests = new Map();
+
+// Whether the request with the given id is still waiting for its response
+export function isPending(id) {
+    return pendingRequests.has(id);
+}
+
+// Send a request wrapped in the protocol envelope. Returns the request id.
+export function sendMessage(type, payload = {}) {
+    const message = {
+        type: type,
+        id: String(++lastRequestID),
+        version: PROTOCOL_VERSION,
+        payload: payload,
+    };
     if (socket.readyState === WebSocket.OPEN) {
         socket.send(JSON.stringify(message));
+        pendingRequests.set(message.id, message);
         console.log('Sent Message:', message);
     } else {
         console.error('WebSocket is not open. Unable to send message.');
     }
+    return message.id;
+}
+
+// Request the first page of the post feed, or with more set the page after
+// the posts already loaded, using the category and sort order in the state
+export function requestFeed(more = false) {
+    const state = getState();
+    const payload = { category: state.feedCategory, sort: state.feedSort, subscribed: state.feedSubscribed };
+    if (more) {
+        if (!state.feedCursor) {
+            return;
+        }
+        payload.cursor = state.feedCursor;
+    } else {
+        syncCategoryTopics();
+    }
+    sendMessage("getFeed", payload);
+}
+
+// The category topics this connection is subscribed to
+const subscribedCategoryTopics = new Set();
+
+// Follow the categories the feed shows so their new posts arrive live. New
+// posts in the user's subscribed categories arrive without topics.
+function syncCategoryTopics() {
+    const state = getState();
+    if (socket.readyState !== WebSocket.OPEN || !state.isAuthenticated || !Array.isArray(state.allCategories)) {
+        return;
+    }
+    // Archived categories get no new posts and cannot be followed
+    let categories = [];
+    if (state.feedCategory) {
+        categories = state.allCategories.filter(category => category.category === state.feedCategory && !category.archived);
+    } else if (!state.feedSubscribed) {
+        categories = state.allCategories.filter(category => !category.archived);
+    }
+    const wanted = new Set(categories.map(category => `category:${category.category_id}`));
+    subscribedCategoryTopics.forEach((topic) => {
+        if (!wanted.has(topic)) {
+            sendMessage("unsubscribe", { topic: topic });
+            subscribedCategoryTopics.delete(topic);
+        }
+    });
+    wanted.forEach((topic) => {
+        if (!subscribedCategoryTopics.has(topic)) {
+            sendMessage("subscribe", { topic: topic });
+            subscribedCategoryTopics.add(topic);
+        }
+    });
+}
+
+// Whether a new post belongs in the feed the user is looking at
+function postInFeed(post, categoryIDs) {
+    const state = getState();
+    if (state.feedCategory && !post.post_category.split(" ").includes(state.feedCategory)) {
+        return false;
+    }
+    if (state.feedSubscribed) {
+        return categoryIDs.some(id => state.categorySubscriptions.includes(id));
+    }
+    return true;
+}
+
+// Show a short notice that goes away by itself
+function showToast(text) {
+    const toast = document.createElement("div");
+    toast.className = "toast";
+    toast.textContent = text;
+    document.body.appendChild(toast);
+    setTimeout(() => toast.remove(), 5000);
+}
+
+// The post whose live updates the page wants, and the one this connection
+// is subscribed to
+let watchedPostID = null;
+let subscribedPostID = null;
+
+// Receive live updates about a post, or about none with null
+export function watchPost(postID) {
+    watchedPostID = postID;
+    syncPostSubscription();
+}
+
+// Subscribe to the watched post once the connection is logged in
+function syncPostSubscription() {
+    if (subscribedPostID === watchedPostID) {
+        return;
+    }
+    if (socket.readyState !== WebSocket.OPEN || !getState().isAuthenticated) {
+        return;
+    }
+    if (subscribedPostID !== null) {
+        sendMessage("unsubscribe", { topic: `post:${subscribedPostID}` });
+    }
+    if (watchedPostID !== null) {
+        // Subscribe first so no comment is missed between loading and subscribing
+        sendMessage("subscribe", { topic: `post:${watchedPostID}` });
+        sendMessage("getComments", { postID: watchedPostID });
+    }
+    subscribedPostID = watchedPostID;
+}
+
+// The username of the profile the page shows, and of the one last requested
+let watchedProfile = null;
+let loadedProfile = null;
+
+// Load a profile for the page, or none with null
+export function watchProfile(username) {
+    watchedProfile = username;
+    loadWatchedProfile();
+}
+
+// Request the watched profile once the connection is logged in
+function loadWatchedProfile() {
+    if (loadedProfile === watchedProfile) {
+        return;
+    }
+    if (socket.readyState !== WebSocket.OPEN || !getState().isAuthenticated) {
+        return;
+    }
+    if (watchedProfile !== null) {
+        sendMessage("getProfile", { username: watchedProfile });
+    }
+    loadedProfile = watchedProfile;
+}
+
+// Apply change to the comments of the tree if it belongs to the post
+function updateCommentTree(tree, postID, change) {
+    if (tree.postID !== postID) {
+        return tree;
+    }
+    return { ...tree, comments: change(tree.comments) };
+}
+
+// Apply fn to every comment of a tree, replies included
+function mapComments(comments, fn) {
+    return comments.map(comment => {
+        const mapped = fn(comment);
+        return mapped.replies ? { ...mapped, replies: mapComments(mapped.replies, fn) } : mapped;
+    });
+}
+
+// Apply change to a post wherever it is kept, in the posts loaded and in the feed
+function updatePost(postID, change) {
+    const state = getState();
+    const update = post => post.post_id === postID ? change(post) : post;
+    updateState({
+        allPosts: state.allPosts.map(update),
+        feedPosts: state.feedPosts.map(update)
+    });
+}
+
+// Show a renamed category under its new name in the posts and the feed filter
+function renameCategory(previous, name) {
+    const state = getState();
+    const rename = post => ({
+        ...post,
+        post_category: post.post_category.split(" ").map(category => category === previous ? name : category).join(" ")
+    });
+    updateState({
+        allPosts: state.allPosts.map(rename),
+        feedPosts: state.feedPosts.map(rename),
+        feedCategory: state.feedCategory === previous ? name : state.feedCategory
+    });
+}
+
+// Store new like and dislike counts of a post, or of a comment when commentID is set
+function applyReactionCounts(counts) {
+    const state = getState();
+    const update = item => ({ ...item, likes: counts.likes, dislikes: counts.dislikes });
+    if (counts.commentID) {
+        updateState({
+            commentTree: updateCommentTree(state.commentTree, counts.postID, comments =>
+                mapComments(comments, comment => comment.comment_id === counts.commentID ? update(comment) : comment))
+        });
+        return;
+    }
+    updateState({
+        allPosts: state.allPosts.map(post => post.post_id === counts.postID ? update(post) : post),
+        feedPosts: state.feedPosts.map(post => post.post_id === counts.postID ? update(post) : post)
+    });
 }
 
 export function receiveWebSocketMessage(event) {
@@ -49,109 +300,430 @@ export function receiveWebSocketMessage(event) {
         }
         let state;
 
+        // Match the response to the request that triggered it
+        let request = null;
+        if (data.id && pendingRequests.has(data.id)) {
+            request = pendingRequests.get(data.id);
+            pendingRequests.delete(data.id);
+        }
+
         switch (data.type) {
             case "Registration":
+                if (pendingCredentials) {
+                    // Registering does not log in; log in over HTTP to get the session cookie
+                    const { identifier, password } = pendingCredentials;
+                    pendingCredentials = null;
+                    loginOverHTTP(identifier, password);
+                }
+                break;
+
             case "Login":
                 updateState({
                     isAuthenticated: data.data.isAuthenticated,
                     loggedInUsername: data.data.loggedInUsername,
-                    allMessagesForUser: data.data.allMessages
+                    role: data.data.role,
+                    categorySubscriptions: data.data.subscriptions,
+                    lastMessageAt: Object.fromEntries(
+                        data.data.conversations.map(conversation => [conversation.peer, conversation.lastMessageAt])
+                    )
                 });
                 
                 state = getState();
                 updateUI(state.loggedInUsername);
 
-                const homePage = {
-                    message: "homePage"
-                };
-                sendMessage(homePage);
+                sendMessage("homePage");
+                requestFeed();
                 router();
+                syncPostSubscription();
+                loadWatchedProfile();
                 break;
 
             case "allData":
+                // Posts come with the feed and comments with getComments
                 updateState({
-                    allPosts: data.data.allPosts,
                     AllUsernames: data.data.allUsernames,
                     allCategories: data.data.allCategories,
-                    allComments: data.data.allComments,
                     NotifyAllUsersOnlineStatus: data.data.usersOnline
                 });
+                syncCategoryTopics();
                 router();
                 break;
 
-            case "createdPost":
+            case "feed":
+                state = getState();
+                // Ignore pages of a feed the user already switched away from
+                if (data.data.category === state.feedCategory && data.data.sort === state.feedSort &&
+                    data.data.subscribed === state.feedSubscribed) {
+                    // Posts only reach this connection through the feed, keep them for the post page
+                    const feedIDs = new Set(data.data.posts.map(post => post.post_id));
+                    const allPosts = state.allPosts.filter(post => !feedIDs.has(post.post_id));
+                    updateState({
+                        allPosts: [...allPosts, ...data.data.posts],
+                        feedPosts: data.data.continued ? [...state.feedPosts, ...data.data.posts] : data.data.posts,
+                        feedCursor: data.data.nextCursor
+                    });
+                    router();
+                }
+                break;
+
+            case "newPost":
+                // Sent to subscribers of the post's categories and to connections showing them
+                state = getState();
+                const newPost = data.data.post;
+                if (!state.allPosts.some(post => post.post_id === newPost.post_id)) {
+                    updateState({ allPosts: [...state.allPosts, newPost] });
+                }
+                if (newPost.username !== state.loggedInUsername &&
+                    data.data.categoryIDs.some(id => state.categorySubscriptions.includes(id))) {
+                    showToast(`New post in ${newPost.post_category}: ${newPost.title} by ${newPost.username}`);
+                }
+                if (postInFeed(newPost, data.data.categoryIDs)) {
+                    requestFeed();
+                }
+                router();
+                break;
+
+            case "profile":
+                updateState({ profile: data.data.profile });
+                router();
+                break;
+
+            case "privacyUpdated":
+                state = getState();
+                if (state.profile && state.profile.privacy) {
+                    updateState({ profile: { ...state.profile, privacy: data.data.privacy } });
+                }
+                alert("Privacy settings saved");
+                break;
+
+            case "profileUpdated":
+                updateState({ profile: data.data.profile });
+                router();
+                alert(data.data.revokedSessions
+                    ? `Profile updated, ${data.data.revokedSessions} other session(s) logged out`
+                    : "Profile updated");
+                break;
+
+            case "passwordChanged":
+                alert(`Password changed, ${data.data.revokedSessions} other session(s) logged out`);
+                break;
+
+            case "accountDeleted":
+                // The sessionRevoked that follows logs this connection out
+                alert("Your account was deleted");
+                break;
+
+            case "categorySubscriptions":
+                // Sent on every connection of the user when the subscriptions change
+                updateState({ categorySubscriptions: data.data.subscriptions });
+                if (getState().feedSubscribed) {
+                    updateState({ feedPosts: [], feedCursor: null });
+                    requestFeed();
+                }
+                router();
+                break;
+
+            case "postEdited":
+                // Sent to whoever edited the post and to the connections showing it
+                updatePost(data.data.post.post_id, post => ({ ...post, ...data.data.post }));
+                if (request) {
+                    updateState({
+                        editingPostID: null,
+                        postRevisions: { postID: null, revisions: [] }
+                    });
+                }
+                router();
+                break;
+
+            case "postDeleted":
+                // Sent to whoever deleted the post and to the connections showing it
+                state = getState();
                 updateState({
-                    allPosts: data.data.allPosts
+                    allPosts: state.allPosts.filter(post => post.post_id !== data.data.postID),
+                    feedPosts: state.feedPosts.filter(post => post.post_id !== data.data.postID)
                 });
-                navigateTo("/");
                 router();
                 break;
 
+            case "commentDeleted":
+                state = getState();
+                updatePost(data.data.postID, post => post.comment_count
+                    ? { ...post, comment_count: post.comment_count - 1 }
+                    : post);
+                state = getState();
+                updateState({
+                    commentTree: updateCommentTree(state.commentTree, data.data.postID, comments =>
+                        mapComments(comments, comment => comment.comment_id === data.data.commentID
+                            ? { ...comment, username: "", content: "", deleted: true }
+                            : comment))
+                });
+                router();
+                break;
+
+            case "comments":
+                if (data.data.postID === watchedPostID) {
+                    // The post comes along, for pages opened without it in the feed
+                    if (getState().allPosts.some(post => post.post_id === data.data.postID)) {
+                        updatePost(data.data.postID, post => ({ ...post, ...data.data.post }));
+                    } else {
+                        updateState({ allPosts: [...getState().allPosts, data.data.post] });
+                    }
+                    updateState({
+                        commentTree: {
+                            postID: data.data.postID,
+                            comments: data.data.comments,
+                            maxDepth: data.data.maxDepth
+                        }
+                    });
+                    router();
+                }
+                break;
+
             case "newComment":
+            case "commentAdded":
+                updatePost(data.data.postID, post => ({ ...post, comment_count: (post.comment_count || 0) + 1 }));
+                // Place the new comment under its parent, or at the end for a comment on the post
+                state = getState();
+                updateState({
+                    commentTree: updateCommentTree(state.commentTree, data.data.postID, comments => {
+                        const comment = data.data.comment;
+                        if (!comment.parent_id) {
+                            return [...comments, comment];
+                        }
+                        return mapComments(comments, parent => parent.comment_id === comment.parent_id
+                            ? { ...parent, replies: [...(parent.replies || []), comment], reply_count: (parent.reply_count || 0) + 1 }
+                            : parent);
+                    })
+                });
+                router();
+                break;
+
+            case "reacted":
+            case "reactionsUpdated":
+                applyReactionCounts(data.data);
+                router();
+                break;
+
+            case "postRevisions":
                 updateState({
-                    allComments: data.data.allComments
+                    postRevisions: data.data
                 });
                 router();
                 break;
 
+            case "searchResults":
+                state = getState();
+                // Only show the results of the latest search
+                if (data.data.query === state.searchQuery) {
+                    updateState({
+                        searchResults: data.data.results
+                    });
+                    router();
+                }
+                break;
+
+            case "createdPost":
+                // The feed is refreshed by the newPost that follows
+                navigateTo("/");
+                router();
+                break;
+
             case "Error":
+                if (request) {
+                    console.error(`Request ${request.id} (${request.type}) failed:`, data.message);
+                }
+                pendingCredentials = null;
                 updateState({
                     errorMessage: data.message
                 });
                 navigateTo("/error");
                 break;
 
-            case "homePageUpdate":
+            case "updatAllUsersOnline":                
                 state = getState();
                 if (state.isAuthenticated) {
                     updateState({
-                        allPosts: data.data.allPosts,
-                        AllUsernames: data.data.allUsernames,
-                        allCategories: data.data.allCategories,
-                        allComments: data.data.allComments,
                         NotifyAllUsersOnlineStatus: data.data.usersOnline
                     });
                     router();
                 }
                 break;
 
-            case "updatAllUsersOnline":                
+            case "categoryCreated":
+            case "categoryUpdated":
+            case "categoryArchived":
+                // Sent to the admin who changed the category and to everyone else
                 state = getState();
                 if (state.isAuthenticated) {
+                    const changed = data.data.category;
+                    const previous = data.data.previous;
                     updateState({
-                        NotifyAllUsersOnlineStatus: data.data.usersOnline
+                        allCategories: [...state.allCategories.filter(category => category.category_id !== changed.category_id), changed]
+                            .sort((a, b) => a.position - b.position || a.category_id - b.category_id)
                     });
+                    if (previous && previous !== changed.category) {
+                        renameCategory(previous, changed.category);
+                    }
+                    syncCategoryTopics();
                     router();
                 }
                 break;
 
-            case "updateAllPosts":
+            case "categoriesReordered":
                 state = getState();
                 if (state.isAuthenticated) {
+                    const order = data.data.categoryIDs;
                     updateState({
-                        allPosts: data.data.allPosts
+                        allCategories: state.allCategories
+                            .map(category => ({ ...category, position: order.indexOf(category.category_id) + 1 }))
+                            .sort((a, b) => a.position - b.position)
                     });
                     router();
                 }
                 break;
 
-            case "updateAllComments":
+            case "userDeleted":
+                // The account's posts are gone and its comments are left as tombstones
                 state = getState();
                 if (state.isAuthenticated) {
+                    const username = data.data.username;
                     updateState({
-                        allComments: data.data.allComments
+                        allPosts: state.allPosts.filter(post => post.username !== username),
+                        feedPosts: state.feedPosts.filter(post => post.username !== username),
+                        AllUsernames: Array.isArray(state.AllUsernames) ? state.AllUsernames.filter(name => name !== username) : state.AllUsernames,
+                        commentTree: { ...state.commentTree, comments: mapComments(state.commentTree.comments, comment => comment.username === username
+                            ? { ...comment, username: "", content: "", deleted: true }
+                            : comment) }
                     });
                     router();
                 }
                 break;
-            case "updateAllMessages":
+
+            case "sessionList":
+                updateState({
+                    sessions: data.data.sessions
+                });
+                break;
+
+            case "sessionRevoked":
+                // This device was logged out from another one
+                resetState();
+                updateUI(null);
+                navigateTo("/");
+                break;
+
+            case "conversation":
+                state = getState();
+                if (data.data.peer === state.selectedChatUsername) {
+                    // A request with a cursor loaded older messages, one without the latest page
+                    updateState({
+                        chatMessages: data.data.before ? [...data.data.messages, ...state.chatMessages] : data.data.messages,
+                        chatHasMore: data.data.hasMore
+                    });
+                    router();
+                }
+                break;
+
+            case "newMessageAdd":
+            case "newPrivateMessage":
+                // The sending connection gets the response, the other
+                // connections of the two participants the update
                 state = getState();
                 if (state.isAuthenticated) {
+                    const message = data.data.message;
+                    const peer = message.sender === state.loggedInUsername ? message.receiver : message.sender;
+                    const changes = {
+                        lastMessageAt: { ...state.lastMessageAt, [peer]: message.created_at }
+                    };
+                    if (peer === state.selectedChatUsername && !state.chatMessages.some(known => known.message_ID === message.message_ID)) {
+                        changes.chatMessages = [...state.chatMessages, message];
+                    }
+                    updateState(changes);
+                    router();
+                }
+                break;
+
+            case "postLocked":
+                // Sent to the moderator and to the connections showing the post
+                updatePost(data.data.postID, post => ({ ...post, locked: data.data.locked }));
+                router();
+                break;
+
+            case "roleChanged":
+                // Sent to the admin who changed the role and to the user who got it
+                state = getState();
+                if (data.data.username === state.loggedInUsername) {
+                    updateState({ role: data.data.role });
+                    updateUI(state.loggedInUsername);
+                    router();
+                } else if (request) {
+                    alert(`${data.data.username} is now ${data.data.role === "admin" ? "an" : "a"} ${data.data.role}`);
+                }
+                break;
+
+            case "accountBanned":
+                // The session is revoked right after this
+                alert(data.data.until
+                    ? `Your account is suspended until ${new Date(data.data.until).toLocaleString()}: ${data.data.reason}`
+                    : `Your account is banned: ${data.data.reason}`);
+                break;
+
+            case "userBanned":
+                alert(`${data.data.username} can no longer use the forum`);
+                break;
+
+            case "userUnbanned":
+                alert(`${data.data.username} can use the forum again`);
+                break;
+
+            case "reportFiled":
+                alert("Thank you, the moderators will look at your report.");
+                break;
+
+            case "reports":
+                if (data.data.status === getState().moderationStatus) {
+                    updateState({ moderationReports: data.data.reports });
+                    router();
+                }
+                break;
+
+            case "newReport":
+                // Sent to every moderator
+                state = getState();
+                if (state.moderationStatus === "open") {
+                    updateState({ moderationReports: [data.data.report, ...state.moderationReports] });
+                    router();
+                }
+                break;
+
+            case "reportClosed":
+                // Closed reports leave the queue of open ones
+                state = getState();
+                if (state.moderationStatus === "open") {
                     updateState({
-                        allMessagesForUser: data.data.allMessages
+                        moderationReports: state.moderationReports.filter(report => !data.data.reportIDs.includes(report.report_id))
                     });
                     router();
                 }
+                break;
+
+            case "moderationLog":
+                updateState({ moderationLog: data.data.entries });
+                router();
+                break;
+
+            case "messageReacted":
+            case "messageReactionsUpdated":
+                // Only sent to the two participants of the conversation
+                state = getState();
+                updateState({
+                    chatMessages: state.chatMessages.map(message => message.message_ID === data.data.messageID
+                        ? { ...message, reactions: data.data.reactions }
+                        : message)
+                });
+                router();
+                break;
+
             default:
                 console.warn('Unhandled message type:', data.type);
         }
@@ -168,11 +740,13 @@ function updateUI(loggedInUsername) {
     profileDiv.innerHTML = '';
 
     if (loggedInUsername) {
+        const role = getState().role;
+        const isModerator = role === "moderator" || role === "admin";
     
         // Update UI with the global variable
         profileDiv.innerHTML = `
             <div class="dropdown">
-                <button class="greeting">
+                <button class="greeting" id="greetingButton">
                     Hi, ${loggedInUsername}
                 </button>
                 <div class="logout" id="logoutButton" data-link>
@@ -181,8 +755,15 @@ function updateUI(loggedInUsername) {
                 <div class="chat-btn" id="chatsButton" data-link>
                     <img class="sign" src="../static/images/chat.png">
                 </div>
+                <button class="greeting" id="searchButton">Search</button>
+                ${isModerator ? '<button class="greeting" id="moderationButton">Moderation</button>' : ''}
             </div>
         `;
+        // The greeting leads to the user's own profile
+        document.getElementById('greetingButton').addEventListener('click', function() {
+            navigateTo(`/profile/${encodeURIComponent(loggedInUsername)}`);
+        });
+
         const chatsButton = document.getElementById('chatsButton');
 
         // Add an event listener to the button
@@ -190,6 +771,23 @@ function updateUI(loggedInUsername) {
             navigateTo("/chats");
         });
 
+        const searchButton = document.getElementById('searchButton');
+
+        // Add an event listener to the button
+        searchButton.addEventListener('click', function() {
+            navigateTo("/search");
+        });
+
+        // Moderators get to the reports and the moderation log
+        const moderationButton = document.getElementById('moderationButton');
+        if (moderationButton) {
+            moderationButton.addEventListener('click', function() {
+                sendMessage("listReports", { status: getState().moderationStatus });
+                sendMessage("getModerationLog");
+                navigateTo("/moderation");
+            });
+        }
+
         const logoutButton = document.getElementById('logoutButton');
 
         // Add an event listener to the button
@@ -217,25 +815,20 @@ function updateUI(loggedInUsername) {
 
 
 // Function to handle logout
-function handleLogout() {
-    let state = getState();
-    const userLeft = {
-        message: "userLogout",
-        username: state.loggedInUsername,
-    };
-    sendMessage(userLeft);
+async function handleLogout() {
+    let state;
+    sendMessage("userLogout");
+
+    // Drop the session cookie so a reload does not log back in
+    try {
+        await fetch('/api/session', { method: 'DELETE' });
+    } catch (error) {
+        console.error('Logout request failed:', error);
+    }
 
     resetState();
     state = getState();
     updateUI(state.loggedInUsername);
     navigateTo("/")
     router();
-    setTimeout(() => {
-        // Close the WebSocket connection
-        if (socket.readyState === WebSocket.OPEN) {
-            socket.close();
-        }
-      }, 2000);
-    
-
 }
\ No newline at end of file